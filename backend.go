@@ -0,0 +1,26 @@
+package gomc
+
+type BackendType int
+
+const (
+	// BACKEND_DEFAULT is libmemcached when built with cgo and the native
+	// backend otherwise.
+	BACKEND_DEFAULT BackendType = iota
+	BACKEND_LIBMEMCACHED
+	BACKEND_NATIVE
+)
+
+// newBackend builds a client, config being the libmemcached configuration
+// string matching servers, which the native backend does not need.
+func newBackend(backend BackendType, config string, servers []serverConfig, pooled bool, maxSize int, encoding EncodingType) (Client, error) {
+	if backend == BACKEND_DEFAULT {
+		backend = defaultBackend
+	}
+	switch backend {
+	case BACKEND_LIBMEMCACHED:
+		return newLibmemcached(config, servers, pooled, maxSize, encoding)
+	case BACKEND_NATIVE:
+		return newNative(servers, maxSize, encoding)
+	}
+	return nil, invalidOptions("unknown backend %d", backend)
+}
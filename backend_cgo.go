@@ -0,0 +1,22 @@
+//go:build cgo
+
+package gomc
+
+const (
+	defaultBackend = BACKEND_LIBMEMCACHED
+)
+
+func newLibmemcached(config string, servers []serverConfig, pooled bool, maxSize int, encoding EncodingType) (Client, error) {
+	if pooled {
+		pool, err := newPoolWithConfig(config, servers, maxSize, encoding)
+		if err != nil {
+			return nil, err
+		}
+		return pool, nil
+	}
+	mc, err := newMemcachedWithConfig(config, servers, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return mc, nil
+}
@@ -0,0 +1,59 @@
+//go:build cgo
+
+package gomc
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestBackendServerForKey(t *testing.T) {
+	servers := []string{"localhost:11211", "localhost:11212", "localhost:11213", "localhost:11214"}
+	tests := []struct {
+		hash         HashType
+		distribution DistributionType
+	}{
+		{HASH_DEFAULT, DISTRIBUTION_MODULA},
+		{HASH_MD5, DISTRIBUTION_MODULA},
+		{HASH_CRC, DISTRIBUTION_MODULA},
+		{HASH_FNV1A_32, DISTRIBUTION_MODULA},
+		{HASH_MURMUR, DISTRIBUTION_MODULA},
+		{HASH_DEFAULT, DISTRIBUTION_CONSISTENT},
+		{HASH_MD5, DISTRIBUTION_CONSISTENT_KETAMA},
+		{HASH_FNV1_64, DISTRIBUTION_CONSISTENT_KETAMA},
+	}
+
+	// Both backends must send a key to the same server, or they can not share
+	// a cluster.
+	for _, test := range tests {
+		var clients [2]Client
+		for i, backend := range []BackendType{BACKEND_LIBMEMCACHED, BACKEND_NATIVE} {
+			mc, err := NewClientWithOptions(Options{
+				Servers:      servers,
+				Hash:         test.hash,
+				Distribution: test.distribution,
+				Backend:      backend,
+			})
+			if err != nil {
+				t.Fatal("Fail to new client:", err)
+			}
+			defer mc.Close()
+			clients[i] = mc
+		}
+
+		for i := 0; i < 100; i++ {
+			key := "test-key:" + strconv.Itoa(i)
+			libmemcached, err := clients[0].ServerForKey(key)
+			if err != nil {
+				t.Error("Fail to get server for key:", err)
+			}
+			native, err := clients[1].ServerForKey(key)
+			if err != nil {
+				t.Error("Fail to get server for key:", err)
+			}
+			if native.Name() != libmemcached.Name() {
+				t.Error("Error server for key:", key, native.Name(), ", expect:", libmemcached.Name(), ", hash:", test.hash, ", distribution:", test.distribution)
+			}
+		}
+	}
+}
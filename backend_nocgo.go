@@ -0,0 +1,17 @@
+//go:build !cgo
+
+package gomc
+
+const (
+	defaultBackend = BACKEND_NATIVE
+)
+
+func newLibmemcached(config string, servers []serverConfig, pooled bool, maxSize int, encoding EncodingType) (Client, error) {
+	return nil, invalidOptions("libmemcached backend built without cgo")
+}
+
+// Without libmemcached, config can only be checked by the Go parser.
+func checkConfiguration(config string) error {
+	_, err := ParseConfig(config)
+	return err
+}
@@ -1,6 +1,8 @@
 package gomc
 
 import (
+	"errors"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -8,40 +10,485 @@ import (
 const (
 	_CONFIG_SEPARATOR = " "
 
-	_CONFIG_SERVER_PREFIX = "--SERVER="
-	_CONFIG_SOCKET_PREFIX = "--SOCKET="
-
 	_CONFIG_POOL_MIN = "--POOL-MIN="
 	_CONFIG_POOL_MAX = "--POOL-MAX="
+
+	_CONFIG_WEIGHT_SEPARATOR = "/?"
 )
 
+type serverConfig struct {
+	host       string
+	port       int
+	weight     uint32
+	connection ConnectionType
+}
+
+func (self serverConfig) name() string {
+	if self.connection == CONNECTION_UNIX_SOCKET {
+		return self.host
+	}
+	return net.JoinHostPort(self.host, strconv.Itoa(self.port))
+}
+
+func newServerConfig(host string, port int, weight uint32) serverConfig {
+	if strings.HasPrefix(host, "/") {
+		return serverConfig{host: host, weight: weight, connection: CONNECTION_UNIX_SOCKET}
+	}
+	if port == 0 {
+		port = DEFAULT_PORT
+	}
+	return serverConfig{host: host, port: port, weight: weight, connection: CONNECTION_TCP}
+}
+
+func parseServer(server string) serverConfig {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return newServerConfig(server, 0, 1)
+	}
+	p, _ := strconv.Atoi(port)
+	return newServerConfig(host, p, 1)
+}
+
+func parseServers(servers []string) []serverConfig {
+	configs := make([]serverConfig, len(servers))
+	for i, server := range servers {
+		configs[i] = parseServer(server)
+	}
+	return configs
+}
+
 func join(options []string) string {
 	return strings.Join(options, _CONFIG_SEPARATOR)
 }
 
-func clientOptions(servers []string) (options []string) {
+func serverOption(server serverConfig) string {
+	return configServerOption(server).String()
+}
+
+func clientOptions(servers []serverConfig) (options []string) {
 	options = make([]string, len(servers))
 	for i, server := range servers {
-		if strings.HasPrefix(server, "/") {
-			options[i] = _CONFIG_SOCKET_PREFIX + server
-		} else {
-			options[i] = _CONFIG_SERVER_PREFIX + server
-		}
+		options[i] = serverOption(server)
 	}
 	return
 }
 
-func clientConfig(servers []string) string {
+func clientConfig(servers []serverConfig) string {
 	return join(clientOptions(servers))
 }
 
-func poolOptions(servers []string, initSize, maxSize int) (options []string) {
+func poolOptions(servers []serverConfig, initSize, maxSize int) (options []string) {
 	options = clientOptions(servers)
 	options = append(options, _CONFIG_POOL_MIN+strconv.Itoa(initSize))
 	options = append(options, _CONFIG_POOL_MAX+strconv.Itoa(maxSize))
 	return
 }
 
-func poolConfig(servers []string, initSize, maxSize int) string {
+func poolConfig(servers []serverConfig, initSize, maxSize int) string {
 	return join(poolOptions(servers, initSize, maxSize))
 }
+
+type configKind int
+
+const (
+	_CONFIG_KIND_FLAG configKind = iota
+	_CONFIG_KIND_NUMBER
+	_CONFIG_KIND_OPTIONAL_NUMBER
+	_CONFIG_KIND_STRING
+	_CONFIG_KIND_SERVER
+	_CONFIG_KIND_SOCKET
+	_CONFIG_KIND_HASH
+	_CONFIG_KIND_DISTRIBUTION
+)
+
+const (
+	_CONFIG_OPTION_PREFIX = "--"
+	_CONFIG_VALUE_PREFIX  = "="
+	_CONFIG_QUOTE         = `"`
+)
+
+var (
+	configKinds = map[string]configKind{
+		"SERVER":                 _CONFIG_KIND_SERVER,
+		"SOCKET":                 _CONFIG_KIND_SOCKET,
+		"BINARY-PROTOCOL":        _CONFIG_KIND_FLAG,
+		"BUFFER-REQUESTS":        _CONFIG_KIND_FLAG,
+		"HASH-WITH-NAMESPACE":    _CONFIG_KIND_FLAG,
+		"NOREPLY":                _CONFIG_KIND_FLAG,
+		"RANDOMIZE-REPLICA-READ": _CONFIG_KIND_FLAG,
+		"SORT-HOSTS":             _CONFIG_KIND_FLAG,
+		"SUPPORT-CAS":            _CONFIG_KIND_FLAG,
+		"TCP-NODELAY":            _CONFIG_KIND_FLAG,
+		"TCP-KEEPALIVE":          _CONFIG_KIND_FLAG,
+		"USE-UDP":                _CONFIG_KIND_FLAG,
+		"VERIFY-KEY":             _CONFIG_KIND_FLAG,
+		"CONNECT-TIMEOUT":        _CONFIG_KIND_NUMBER,
+		"IO-BYTES-WATERMARK":     _CONFIG_KIND_NUMBER,
+		"IO-KEY-PREFETCH":        _CONFIG_KIND_NUMBER,
+		"IO-MSG-WATERMARK":       _CONFIG_KIND_NUMBER,
+		"NUMBER-OF-REPLICAS":     _CONFIG_KIND_NUMBER,
+		"POLL-TIMEOUT":           _CONFIG_KIND_NUMBER,
+		"RCV-TIMEOUT":            _CONFIG_KIND_NUMBER,
+		"RETRY-TIMEOUT":          _CONFIG_KIND_NUMBER,
+		"SERVER-FAILURE-LIMIT":   _CONFIG_KIND_NUMBER,
+		"SND-TIMEOUT":            _CONFIG_KIND_NUMBER,
+		"SOCKET-RECV-SIZE":       _CONFIG_KIND_NUMBER,
+		"SOCKET-SEND-SIZE":       _CONFIG_KIND_NUMBER,
+		"TCP-KEEPIDLE":           _CONFIG_KIND_NUMBER,
+		"POOL-MIN":               _CONFIG_KIND_NUMBER,
+		"POOL-MAX":               _CONFIG_KIND_NUMBER,
+		"REMOVE-FAILED-SERVERS":  _CONFIG_KIND_OPTIONAL_NUMBER,
+		"NAMESPACE":              _CONFIG_KIND_STRING,
+		"CONFIGURE-FILE":         _CONFIG_KIND_STRING,
+		"HASH":                   _CONFIG_KIND_HASH,
+		"KETAMA-HASH":            _CONFIG_KIND_HASH,
+		"DISTRIBUTION":           _CONFIG_KIND_DISTRIBUTION,
+	}
+
+	configHashes = map[string]HashType{
+		"MD5":      HASH_MD5,
+		"CRC":      HASH_CRC,
+		"FNV1_64":  HASH_FNV1_64,
+		"FNV1A_64": HASH_FNV1A_64,
+		"FNV1_32":  HASH_FNV1_32,
+		"FNV1A_32": HASH_FNV1A_32,
+		"HSIEH":    HASH_HSIEH,
+		"MURMUR":   HASH_MURMUR,
+		"JENKINS":  HASH_JENKINS,
+	}
+
+	// Options setting a behavior of the same name, a flag turning it on.
+	configBehaviors = map[string]BehaviorType{
+		"BINARY-PROTOCOL":        BEHAVIOR_BINARY_PROTOCOL,
+		"BUFFER-REQUESTS":        BEHAVIOR_BUFFER_REQUESTS,
+		"HASH-WITH-NAMESPACE":    BEHAVIOR_HASH_WITH_PREFIX_KEY,
+		"NOREPLY":                BEHAVIOR_NOREPLY,
+		"RANDOMIZE-REPLICA-READ": BEHAVIOR_RANDOMIZE_REPLICA_READ,
+		"SORT-HOSTS":             BEHAVIOR_SORT_HOSTS,
+		"SUPPORT-CAS":            BEHAVIOR_SUPPORT_CAS,
+		"TCP-NODELAY":            BEHAVIOR_TCP_NODELAY,
+		"TCP-KEEPALIVE":          BEHAVIOR_TCP_KEEPALIVE,
+		"USE-UDP":                BEHAVIOR_USE_UDP,
+		"VERIFY-KEY":             BEHAVIOR_VERIFY_KEY,
+		"CONNECT-TIMEOUT":        BEHAVIOR_CONNECT_TIMEOUT,
+		"IO-BYTES-WATERMARK":     BEHAVIOR_IO_BYTES_WATERMARK,
+		"IO-KEY-PREFETCH":        BEHAVIOR_IO_KEY_PREFETCH,
+		"IO-MSG-WATERMARK":       BEHAVIOR_IO_MSG_WATERMARK,
+		"NUMBER-OF-REPLICAS":     BEHAVIOR_NUMBER_OF_REPLICAS,
+		"POLL-TIMEOUT":           BEHAVIOR_POLL_TIMEOUT,
+		"RCV-TIMEOUT":            BEHAVIOR_RCV_TIMEOUT,
+		"RETRY-TIMEOUT":          BEHAVIOR_RETRY_TIMEOUT,
+		"SERVER-FAILURE-LIMIT":   BEHAVIOR_SERVER_FAILURE_LIMIT,
+		"SND-TIMEOUT":            BEHAVIOR_SND_TIMEOUT,
+		"SOCKET-RECV-SIZE":       BEHAVIOR_SOCKET_RECV_SIZE,
+		"SOCKET-SEND-SIZE":       BEHAVIOR_SOCKET_SEND_SIZE,
+		"TCP-KEEPIDLE":           BEHAVIOR_TCP_KEEPIDLE,
+	}
+
+	configDistributions = map[string]DistributionType{
+		"CONSISTENT": DISTRIBUTION_CONSISTENT,
+		"MODULA":     DISTRIBUTION_MODULA,
+		"RANDOM":     DISTRIBUTION_RANDOM,
+	}
+)
+
+// ConfigError locates a syntax error at a byte offset of the parsed string.
+type ConfigError struct {
+	Offset  int
+	Message string
+}
+
+func (self *ConfigError) Error() string {
+	return "Config error at offset " + strconv.Itoa(self.Offset) + ": " + self.Message
+}
+
+type ConfigOption struct {
+	Name   string
+	Value  string
+	Offset int
+}
+
+func (self ConfigOption) String() string {
+	option := _CONFIG_OPTION_PREFIX + self.Name
+	if self.Value == "" {
+		return option
+	}
+	if strings.ContainsAny(self.Value, " \t\n") {
+		return option + _CONFIG_VALUE_PREFIX + _CONFIG_QUOTE + self.Value + _CONFIG_QUOTE
+	}
+	return option + _CONFIG_VALUE_PREFIX + self.Value
+}
+
+// Config is the libmemcached option language, e.g.
+// `--SERVER=host:11211/?2 --BINARY-PROTOCOL --HASH=MD5`. Options keep their
+// order so that a parsed config renders back to an equivalent string.
+type Config struct {
+	Options []ConfigOption
+}
+
+func ParseConfig(config string) (self *Config, err error) {
+	self = new(Config)
+	for offset := 0; offset < len(config); {
+		if strings.ContainsRune(" \t\r\n", rune(config[offset])) {
+			offset++
+			continue
+		}
+
+		start := offset
+		if !strings.HasPrefix(config[offset:], _CONFIG_OPTION_PREFIX) {
+			return nil, &ConfigError{offset, "option must start with " + _CONFIG_OPTION_PREFIX}
+		}
+		offset += len(_CONFIG_OPTION_PREFIX)
+
+		end := offset
+		for end < len(config) && !strings.ContainsRune(" \t\r\n=", rune(config[end])) {
+			end++
+		}
+		option := ConfigOption{Name: strings.ToUpper(config[offset:end]), Offset: start}
+		offset = end
+
+		if offset < len(config) && config[offset] == '=' {
+			offset++
+			if strings.HasPrefix(config[offset:], _CONFIG_QUOTE) {
+				closing := strings.Index(config[offset+1:], _CONFIG_QUOTE)
+				if closing < 0 {
+					return nil, &ConfigError{offset, "unterminated quoted value"}
+				}
+				option.Value = config[offset+1 : offset+1+closing]
+				offset += closing + 2
+			} else {
+				end = offset
+				for end < len(config) && !strings.ContainsRune(" \t\r\n", rune(config[end])) {
+					end++
+				}
+				option.Value = config[offset:end]
+				offset = end
+			}
+			if option.Value == "" {
+				return nil, &ConfigError{offset, "empty value for " + option.Name}
+			}
+		}
+
+		if err = option.validate(); err != nil {
+			return nil, err
+		}
+		self.Options = append(self.Options, option)
+	}
+	return
+}
+
+func (self ConfigOption) validate() error {
+	kind, ok := configKinds[self.Name]
+	if !ok {
+		return &ConfigError{self.Offset, "unknown option " + self.Name}
+	}
+
+	valueOffset := self.Offset + len(_CONFIG_OPTION_PREFIX) + len(self.Name) + len(_CONFIG_VALUE_PREFIX)
+	invalid := func(message string) error {
+		return &ConfigError{valueOffset, message + " for " + self.Name}
+	}
+	if kind == _CONFIG_KIND_FLAG {
+		if self.Value != "" {
+			return invalid("unexpected value")
+		}
+		return nil
+	}
+	if self.Value == "" && kind != _CONFIG_KIND_OPTIONAL_NUMBER {
+		return &ConfigError{self.Offset, "missing value for " + self.Name}
+	}
+
+	switch kind {
+	case _CONFIG_KIND_NUMBER, _CONFIG_KIND_OPTIONAL_NUMBER:
+		if _, err := strconv.ParseUint(self.Value, _NUMERIC_BASE, 64); err != nil && self.Value != "" {
+			return invalid("invalid number")
+		}
+	case _CONFIG_KIND_SERVER, _CONFIG_KIND_SOCKET:
+		if _, err := parseServerOption(self.Value, kind == _CONFIG_KIND_SOCKET); err != nil {
+			return invalid(err.Error())
+		}
+	case _CONFIG_KIND_HASH:
+		if _, ok := configHashes[strings.ToUpper(self.Value)]; !ok {
+			return invalid("unknown hash")
+		}
+	case _CONFIG_KIND_DISTRIBUTION:
+		parts := strings.SplitN(strings.ToUpper(self.Value), ",", 2)
+		if _, ok := configDistributions[parts[0]]; !ok {
+			return invalid("unknown distribution")
+		}
+		if len(parts) == 2 {
+			if _, ok := configHashes[parts[1]]; !ok || parts[0] != "CONSISTENT" {
+				return invalid("unknown distribution hash")
+			}
+		}
+	}
+	return nil
+}
+
+func parseServerOption(value string, socket bool) (server serverConfig, err error) {
+	weight := uint64(1)
+	if i := strings.LastIndex(value, _CONFIG_WEIGHT_SEPARATOR); i >= 0 {
+		if weight, err = strconv.ParseUint(value[i+len(_CONFIG_WEIGHT_SEPARATOR):], _NUMERIC_BASE, 32); err != nil || weight == 0 {
+			return server, errors.New("invalid weight")
+		}
+		value = value[:i]
+	}
+
+	if socket {
+		if !strings.HasPrefix(value, "/") {
+			return server, errors.New("socket path must be absolute")
+		}
+		return serverConfig{host: value, weight: uint32(weight), connection: CONNECTION_UNIX_SOCKET}, nil
+	}
+
+	if host, port, e := net.SplitHostPort(value); e == nil {
+		if p, e := strconv.Atoi(port); e != nil || p <= 0 || p > 65535 {
+			return server, errors.New("invalid port")
+		}
+		value = net.JoinHostPort(host, port)
+	}
+	server = parseServer(value)
+	if server.host == "" {
+		return server, errors.New("missing host")
+	}
+	server.weight = uint32(weight)
+	return
+}
+
+func (self *Config) String() string {
+	options := make([]string, len(self.Options))
+	for i, option := range self.Options {
+		options[i] = option.String()
+	}
+	return join(options)
+}
+
+// Get returns the value of the last occurrence of the option name.
+func (self *Config) Get(name string) (value string, ok bool) {
+	name = strings.ToUpper(name)
+	for _, option := range self.Options {
+		if option.Name == name {
+			value, ok = option.Value, true
+		}
+	}
+	return
+}
+
+// Set replaces every occurrence of the option name by a single one, value
+// being empty for a flag.
+func (self *Config) Set(name, value string) error {
+	option := ConfigOption{Name: strings.ToUpper(name), Value: value}
+	if err := option.validate(); err != nil {
+		return err
+	}
+
+	options := self.Options[:0:0]
+	for _, o := range self.Options {
+		if o.Name != option.Name {
+			options = append(options, o)
+		}
+	}
+	self.Options = append(options, option)
+	return nil
+}
+
+func (self *Config) AddServer(host string, port int, weight uint32) {
+	self.Options = append(self.Options, configServerOption(newServerConfig(host, port, weight)))
+}
+
+func configServerOption(server serverConfig) (option ConfigOption) {
+	if server.connection == CONNECTION_UNIX_SOCKET {
+		option = ConfigOption{Name: "SOCKET", Value: server.host}
+	} else {
+		option = ConfigOption{Name: "SERVER", Value: server.name()}
+	}
+	if server.weight > 1 {
+		option.Value += _CONFIG_WEIGHT_SEPARATOR + strconv.FormatUint(uint64(server.weight), _NUMERIC_BASE)
+	}
+	return
+}
+
+func (self *Config) servers() (servers []serverConfig) {
+	for _, option := range self.Options {
+		if kind := configKinds[option.Name]; kind == _CONFIG_KIND_SERVER || kind == _CONFIG_KIND_SOCKET {
+			server, _ := parseServerOption(option.Value, kind == _CONFIG_KIND_SOCKET)
+			servers = append(servers, server)
+		}
+	}
+	return
+}
+
+func (self *Config) poolMax() int {
+	value, _ := self.Get("POOL-MAX")
+	size, _ := strconv.Atoi(value)
+	return size
+}
+
+// Check validates the configuration with libmemcached's own parser.
+func (self *Config) Check() error {
+	return checkConfiguration(self.String())
+}
+
+// apply sets the behaviors and namespace of the options on a client not built
+// from the config string, in the order of the options as libmemcached does.
+func (self *Config) apply(client Client) (err error) {
+	for _, option := range self.Options {
+		value := uint64(1)
+		if kind := configKinds[option.Name]; kind == _CONFIG_KIND_NUMBER || kind == _CONFIG_KIND_OPTIONAL_NUMBER && option.Value != "" {
+			value, _ = strconv.ParseUint(option.Value, _NUMERIC_BASE, 64)
+		}
+		switch option.Name {
+		case "HASH":
+			err = client.SetBehavior(BEHAVIOR_HASH, uint64(configHashes[strings.ToUpper(option.Value)]))
+		case "KETAMA-HASH":
+			err = client.SetBehavior(BEHAVIOR_KETAMA_HASH, uint64(configHashes[strings.ToUpper(option.Value)]))
+		case "DISTRIBUTION":
+			parts := strings.SplitN(strings.ToUpper(option.Value), ",", 2)
+			err = client.SetBehavior(BEHAVIOR_DISTRIBUTION, uint64(configDistributions[parts[0]]))
+			if err == nil && len(parts) == 2 {
+				err = client.SetBehavior(BEHAVIOR_KETAMA_HASH, uint64(configHashes[parts[1]]))
+			}
+		case "REMOVE-FAILED-SERVERS":
+			err = client.SetBehavior(BEHAVIOR_REMOVE_FAILED_SERVERS, 1)
+			if err == nil && option.Value != "" {
+				err = client.SetBehavior(BEHAVIOR_SERVER_FAILURE_LIMIT, value)
+			}
+		case "NAMESPACE":
+			err = client.SetNamespace(option.Value)
+		case "CONFIGURE-FILE":
+			err = notSupported("Option " + option.Name + " requires the libmemcached backend")
+		default:
+			if behavior, ok := configBehaviors[option.Name]; ok {
+				err = client.SetBehavior(behavior, value)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// NewClientWithConfig builds a client of the default backend from a
+// libmemcached config string, making a pool when it sets --POOL-MAX above 1.
+// The native backend gets the options as behaviors, failing on those it does
+// not support.
+func NewClientWithConfig(config string, encoding EncodingType) (Client, error) {
+	parsed, err := ParseConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if err = parsed.Check(); err != nil {
+		return nil, err
+	}
+
+	maxSize := parsed.poolMax()
+	client, err := newBackend(BACKEND_DEFAULT, parsed.String(), parsed.servers(), maxSize > 1, maxSize, encoding)
+	if err != nil || defaultBackend != BACKEND_NATIVE {
+		return client, err
+	}
+	if err = parsed.apply(client); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
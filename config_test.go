@@ -0,0 +1,120 @@
+package gomc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ianoshen/gomc/gomctest"
+)
+
+func TestParseConfig(t *testing.T) {
+	config := `--SERVER=host1:11211/?2 --socket="/tmp/test gomc.sock" --BINARY-PROTOCOL ` +
+		`--CONNECT-TIMEOUT=100 --DISTRIBUTION=consistent,MD5 --HASH=murmur --NAMESPACE=app: --REMOVE-FAILED-SERVERS`
+	parsed, err := ParseConfig(config)
+	if err != nil {
+		t.Fatal("Fail to parse config:", err)
+	}
+
+	if value, ok := parsed.Get("connect-timeout"); !ok || value != "100" {
+		t.Error("Error config value:", value, ", expect:", "100")
+	}
+	if _, ok := parsed.Get("BINARY-PROTOCOL"); !ok {
+		t.Error("Missing flag BINARY-PROTOCOL")
+	}
+
+	expect := []serverConfig{
+		{host: "host1", port: 11211, weight: 2, connection: CONNECTION_TCP},
+		{host: "/tmp/test gomc.sock", weight: 1, connection: CONNECTION_UNIX_SOCKET},
+	}
+	if servers := parsed.servers(); !reflect.DeepEqual(servers, expect) {
+		t.Error("Error servers:", servers, ", expect:", expect)
+	}
+
+	reparsed, err := ParseConfig(parsed.String())
+	if err != nil {
+		t.Fatal("Fail to parse rendered config:", err)
+	}
+	if reparsed.String() != parsed.String() {
+		t.Error("Error round trip:", reparsed.String(), ", expect:", parsed.String())
+	}
+}
+
+func TestParseConfigError(t *testing.T) {
+	cases := map[string]int{
+		"SERVER=host":                      0,
+		"--SERVER=host --UNKNOWN":          14,
+		"--SERVER=host:port":               9,
+		"--SERVER=host --HASH=SHA1":        21,
+		"--SERVER=host --POLL-TIMEOUT=abc": 29,
+		"--SERVER=host --SORT-HOSTS=1":     27,
+		`--NAMESPACE="app`:                 12,
+		"--SERVER=host/?0":                 9,
+	}
+	for config, offset := range cases {
+		_, err := ParseConfig(config)
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) {
+			t.Error("Error parse:", config, err)
+		} else if configErr.Offset != offset {
+			t.Error("Error offset:", config, configErr.Offset, ", expect:", offset)
+		}
+	}
+}
+
+func TestConfigBuild(t *testing.T) {
+	config := new(Config)
+	config.AddServer("localhost", 11211, 1)
+	config.AddServer("/tmp/test-gomc-1.sock", 0, 3)
+	if err := config.Set("HASH", "MD5"); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	if err := config.Set("HASH", "CRC"); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	if err := config.Set("TCP-NODELAY", ""); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	if err := config.Set("POOL-MAX", "many"); err == nil {
+		t.Error("Set invalid number should fail")
+	}
+
+	expect := "--SERVER=localhost:11211 --SOCKET=/tmp/test-gomc-1.sock/?3 --HASH=CRC --TCP-NODELAY"
+	if config.String() != expect {
+		t.Error("Error config:", config.String(), ", expect:", expect)
+	}
+}
+
+func TestNewClientWithConfig(t *testing.T) {
+	gomctest.StartAt(t, testHosts[:2]...)
+
+	config := "--SERVER=" + testHosts[0] + " --SERVER=" + testHosts[1] +
+		" --HASH=MD5 --DISTRIBUTION=CONSISTENT --BINARY-PROTOCOL --POLL-TIMEOUT=300 --NAMESPACE=app:"
+	mc, err := NewClientWithConfig(config, ENCODING_DEFAULT)
+	if err != nil {
+		t.Fatal("Fail to new client:", err)
+	}
+	defer mc.Close()
+
+	behaviors := map[BehaviorType]uint64{
+		BEHAVIOR_HASH:            uint64(HASH_MD5),
+		BEHAVIOR_DISTRIBUTION:    uint64(DISTRIBUTION_CONSISTENT),
+		BEHAVIOR_BINARY_PROTOCOL: 1,
+		BEHAVIOR_POLL_TIMEOUT:    300,
+	}
+	for behavior, expect := range behaviors {
+		if value, err := mc.GetBehavior(behavior); err != nil || value != expect {
+			t.Error("Error behavior:", behavior, value, err, ", expect:", expect)
+		}
+	}
+	if namespace := mc.Namespace(); namespace != "app:" {
+		t.Error("Error namespace:", namespace, ", expect:", "app:")
+	}
+	if err = mc.Set("test-key", "test-value", 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	var value string
+	if err = mc.Get("test-key", &value); err != nil || value != "test-value" {
+		t.Error("Error get:", value, err)
+	}
+}
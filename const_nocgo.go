@@ -0,0 +1,124 @@
+//go:build !cgo
+
+package gomc
+
+// Without cgo the values of libmemcached 1.0.18 are spelled out, so that
+// both backends agree on them.
+const (
+	SUCCESS                          = ReturnType(0)
+	FAILURE                          = ReturnType(1)
+	HOST_LOOKUP_FAILURE              = ReturnType(2)
+	CONNECTION_FAILURE               = ReturnType(3)
+	CONNECTION_BIND_FAILURE          = ReturnType(4)
+	WRITE_FAILURE                    = ReturnType(5)
+	READ_FAILURE                     = ReturnType(6)
+	UNKNOWN_READ_FAILURE             = ReturnType(7)
+	PROTOCOL_ERROR                   = ReturnType(8)
+	CLIENT_ERROR                     = ReturnType(9)
+	SERVER_ERROR                     = ReturnType(10)
+	CONNECTION_SOCKET_CREATE_FAILURE = ReturnType(11)
+	DATA_EXISTS                      = ReturnType(12)
+	DATA_DOES_NOT_EXIST              = ReturnType(13)
+	NOTSTORED                        = ReturnType(14)
+	STORED                           = ReturnType(15)
+	NOTFOUND                         = ReturnType(16)
+	MEMORY_ALLOCATION_FAILURE        = ReturnType(17)
+	PARTIAL_READ                     = ReturnType(18)
+	SOME_ERRORS                      = ReturnType(19)
+	NO_SERVERS                       = ReturnType(20)
+	END                              = ReturnType(21)
+	DELETED                          = ReturnType(22)
+	VALUE                            = ReturnType(23)
+	STAT                             = ReturnType(24)
+	ERRNO                            = ReturnType(26)
+	FAIL_UNIX_SOCKET                 = ReturnType(27)
+	NOT_SUPPORTED                    = ReturnType(28)
+	NO_KEY_PROVIDED                  = ReturnType(29)
+	FETCH_NOTFINISHED                = ReturnType(30)
+	TIMEOUT                          = ReturnType(31)
+	BUFFERED                         = ReturnType(32)
+	BAD_KEY_PROVIDED                 = ReturnType(33)
+	INVALID_HOST_PROTOCOL            = ReturnType(34)
+	SERVER_MARKED_DEAD               = ReturnType(35)
+	UNKNOWN_STAT_KEY                 = ReturnType(36)
+	E2BIG                            = ReturnType(37)
+	INVALID_ARGUMENTS                = ReturnType(38)
+	KEY_TOO_BIG                      = ReturnType(39)
+	AUTH_PROBLEM                     = ReturnType(40)
+	AUTH_FAILURE                     = ReturnType(41)
+	AUTH_CONTINUE                    = ReturnType(42)
+	PARSE_ERROR                      = ReturnType(43)
+	PARSE_USER_ERROR                 = ReturnType(44)
+	DEPRECATED                       = ReturnType(45)
+	IN_PROGRESS                      = ReturnType(46)
+	SERVER_TEMPORARILY_DISABLED      = ReturnType(47)
+	SERVER_MEMORY_ALLOCATION_FAILURE = ReturnType(48)
+	MAXIMUM_RETURN                   = ReturnType(49)
+
+	BEHAVIOR_NO_BLOCK               = BehaviorType(0)
+	BEHAVIOR_TCP_NODELAY            = BehaviorType(1)
+	BEHAVIOR_HASH                   = BehaviorType(2)
+	BEHAVIOR_KETAMA                 = BehaviorType(3)
+	BEHAVIOR_SOCKET_SEND_SIZE       = BehaviorType(4)
+	BEHAVIOR_SOCKET_RECV_SIZE       = BehaviorType(5)
+	BEHAVIOR_CACHE_LOOKUPS          = BehaviorType(6)
+	BEHAVIOR_SUPPORT_CAS            = BehaviorType(7)
+	BEHAVIOR_POLL_TIMEOUT           = BehaviorType(8)
+	BEHAVIOR_DISTRIBUTION           = BehaviorType(9)
+	BEHAVIOR_BUFFER_REQUESTS        = BehaviorType(10)
+	BEHAVIOR_USER_DATA              = BehaviorType(11)
+	BEHAVIOR_SORT_HOSTS             = BehaviorType(12)
+	BEHAVIOR_VERIFY_KEY             = BehaviorType(13)
+	BEHAVIOR_CONNECT_TIMEOUT        = BehaviorType(14)
+	BEHAVIOR_RETRY_TIMEOUT          = BehaviorType(15)
+	BEHAVIOR_KETAMA_WEIGHTED        = BehaviorType(16)
+	BEHAVIOR_KETAMA_HASH            = BehaviorType(17)
+	BEHAVIOR_BINARY_PROTOCOL        = BehaviorType(18)
+	BEHAVIOR_SND_TIMEOUT            = BehaviorType(19)
+	BEHAVIOR_RCV_TIMEOUT            = BehaviorType(20)
+	BEHAVIOR_SERVER_FAILURE_LIMIT   = BehaviorType(21)
+	BEHAVIOR_IO_MSG_WATERMARK       = BehaviorType(22)
+	BEHAVIOR_IO_BYTES_WATERMARK     = BehaviorType(23)
+	BEHAVIOR_IO_KEY_PREFETCH        = BehaviorType(24)
+	BEHAVIOR_HASH_WITH_PREFIX_KEY   = BehaviorType(25)
+	BEHAVIOR_NOREPLY                = BehaviorType(26)
+	BEHAVIOR_USE_UDP                = BehaviorType(27)
+	BEHAVIOR_AUTO_EJECT_HOSTS       = BehaviorType(28)
+	BEHAVIOR_NUMBER_OF_REPLICAS     = BehaviorType(29)
+	BEHAVIOR_RANDOMIZE_REPLICA_READ = BehaviorType(30)
+	BEHAVIOR_CORK                   = BehaviorType(31)
+	BEHAVIOR_TCP_KEEPALIVE          = BehaviorType(32)
+	BEHAVIOR_TCP_KEEPIDLE           = BehaviorType(33)
+	BEHAVIOR_LOAD_FROM_FILE         = BehaviorType(34)
+	BEHAVIOR_REMOVE_FAILED_SERVERS  = BehaviorType(35)
+	BEHAVIOR_DEAD_TIMEOUT           = BehaviorType(36)
+	BEHAVIOR_MAX                    = BehaviorType(37)
+
+	DISTRIBUTION_MODULA                = DistributionType(0)
+	DISTRIBUTION_CONSISTENT            = DistributionType(1)
+	DISTRIBUTION_CONSISTENT_KETAMA     = DistributionType(2)
+	DISTRIBUTION_RANDOM                = DistributionType(3)
+	DISTRIBUTION_CONSISTENT_KETAMA_SPY = DistributionType(4)
+	DISTRIBUTION_CONSISTENT_WEIGHTED   = DistributionType(5)
+	DISTRIBUTION_VIRTUAL_BUCKET        = DistributionType(6)
+	DISTRIBUTION_CONSISTENT_MAX        = DistributionType(7)
+
+	HASH_DEFAULT  = HashType(0)
+	HASH_MD5      = HashType(1)
+	HASH_CRC      = HashType(2)
+	HASH_FNV1_64  = HashType(3)
+	HASH_FNV1A_64 = HashType(4)
+	HASH_FNV1_32  = HashType(5)
+	HASH_FNV1A_32 = HashType(6)
+	HASH_HSIEH    = HashType(7)
+	HASH_MURMUR   = HashType(8)
+	HASH_JENKINS  = HashType(9)
+	HASH_CUSTOM   = HashType(11)
+	HASH_MAX      = HashType(12)
+
+	CONNECTION_TCP         = ConnectionType(0)
+	CONNECTION_UDP         = ConnectionType(1)
+	CONNECTION_UNIX_SOCKET = ConnectionType(2)
+
+	DEFAULT_PORT = int(11211)
+)
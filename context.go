@@ -0,0 +1,424 @@
+//go:build cgo
+
+package gomc
+
+import (
+	"context"
+	"time"
+)
+
+// contextTimeouts are the timeouts a context deadline caps in libmemcached,
+// where the send and receive timeouts only apply to sockets as they are
+// opened: the poll timeout alone bounds a call.
+var contextTimeouts = []timeoutBehavior{
+	{BEHAVIOR_POLL_TIMEOUT, time.Millisecond},
+}
+
+// applyTimeout lowers the contextTimeouts of the connection to timeout and
+// returns a function restoring the previous values. Timeouts already shorter
+// than timeout are left untouched.
+func (self *memcached) applyTimeout(timeout time.Duration) (restore func(), err error) {
+	previous := make(map[BehaviorType]uint64, len(contextTimeouts))
+	restore = func() {
+		for behavior, value := range previous {
+			self.SetBehavior(behavior, value)
+		}
+	}
+
+	for _, t := range contextTimeouts {
+		value := uint64(timeout / t.unit)
+		if value == 0 {
+			value = 1
+		}
+		current, _ := self.GetBehavior(t.behavior)
+		if int64(current) > 0 && current <= value {
+			continue
+		}
+		if err = self.SetBehavior(t.behavior, value); err != nil {
+			restore()
+			return
+		}
+		previous[t.behavior] = current
+	}
+	return
+}
+
+// withContext runs fn with the poll timeout capped by the deadline of ctx. A
+// cgo call can not be interrupted, so cancelling a context without deadline
+// only takes effect before fn starts.
+func (self *memcached) withContext(ctx context.Context, fn func() error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return fn()
+	}
+
+	restore, err := self.applyTimeout(time.Until(deadline))
+	if err != nil {
+		return
+	}
+	defer restore()
+	return contextError(ctx, fn())
+}
+
+func (self *memcachedPool) withContext(ctx context.Context, fn func(*memcached) error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	deadline, _ := ctx.Deadline()
+	conn, err := self.fetch(deadline, !noWait(ctx))
+	defer self.releaseConnection(conn)
+	if err == ErrPoolExhausted && !deadline.IsZero() && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	return conn.withContext(ctx, func() error {
+		return fn(conn)
+	})
+}
+
+func (self *memcached) IncrementContext(ctx context.Context, key string, offset uint32) (value uint64, err error) {
+	err = self.withContext(ctx, func() (err error) {
+		value, err = self.Increment(key, offset)
+		return
+	})
+	return
+}
+
+func (self *memcached) DecrementContext(ctx context.Context, key string, offset uint32) (value uint64, err error) {
+	err = self.withContext(ctx, func() (err error) {
+		value, err = self.Decrement(key, offset)
+		return
+	})
+	return
+}
+
+func (self *memcached) IncrementWithInitialContext(ctx context.Context, key string, offset, initial uint64, expiration time.Duration) (value uint64, err error) {
+	err = self.withContext(ctx, func() (err error) {
+		value, err = self.IncrementWithInitial(key, offset, initial, expiration)
+		return
+	})
+	return
+}
+
+func (self *memcached) DecrementWithInitialContext(ctx context.Context, key string, offset, initial uint64, expiration time.Duration) (value uint64, err error) {
+	err = self.withContext(ctx, func() (err error) {
+		value, err = self.DecrementWithInitial(key, offset, initial, expiration)
+		return
+	})
+	return
+}
+
+func (self *memcached) DeleteContext(ctx context.Context, key string, expiration time.Duration) error {
+	return self.withContext(ctx, func() error {
+		return self.Delete(key, expiration)
+	})
+}
+
+func (self *memcached) ExistContext(ctx context.Context, key string) error {
+	return self.withContext(ctx, func() error {
+		return self.Exist(key)
+	})
+}
+
+func (self *memcached) TouchContext(ctx context.Context, key string, expiration time.Duration) error {
+	return self.withContext(ctx, func() error {
+		return self.Touch(key, expiration)
+	})
+}
+
+func (self *memcached) TouchMultiContext(ctx context.Context, keys []string, expiration time.Duration) error {
+	return self.withContext(ctx, func() error {
+		return self.TouchMulti(keys, expiration)
+	})
+}
+
+func (self *memcached) FlushContext(ctx context.Context, expiration time.Duration) error {
+	return self.withContext(ctx, func() error {
+		return self.Flush(expiration)
+	})
+}
+
+func (self *memcached) GetContext(ctx context.Context, key string, value interface{}) error {
+	return self.withContext(ctx, func() error {
+		return self.Get(key, value)
+	})
+}
+
+func (self *memcached) GetAndTouchContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func() error {
+		return self.GetAndTouch(key, value, expiration)
+	})
+}
+
+func (self *memcached) GetMultiContext(ctx context.Context, keys []string) (res Result, err error) {
+	err = self.withContext(ctx, func() (err error) {
+		res, err = self.GetMulti(keys)
+		return
+	})
+	return
+}
+
+func (self *memcached) GetMultiFuncContext(ctx context.Context, keys []string, fn func(*Item) error) error {
+	return self.withContext(ctx, func() error {
+		return self.GetMultiFunc(keys, fn)
+	})
+}
+
+func (self *memcached) GetWithCASContext(ctx context.Context, key string, value interface{}) (cas uint64, err error) {
+	err = self.withContext(ctx, func() (err error) {
+		cas, err = self.GetWithCAS(key, value)
+		return
+	})
+	return
+}
+
+func (self *memcached) AddContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func() error {
+		return self.Add(key, value, expiration)
+	})
+}
+
+func (self *memcached) ReplaceContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func() error {
+		return self.Replace(key, value, expiration)
+	})
+}
+
+func (self *memcached) SetContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func() error {
+		return self.Set(key, value, expiration)
+	})
+}
+
+func (self *memcached) AppendContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func() error {
+		return self.Append(key, value, expiration)
+	})
+}
+
+func (self *memcached) PrependContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func() error {
+		return self.Prepend(key, value, expiration)
+	})
+}
+
+func (self *memcached) CompareAndSwapContext(ctx context.Context, key string, value interface{}, cas uint64, expiration time.Duration) error {
+	return self.withContext(ctx, func() error {
+		return self.CompareAndSwap(key, value, cas, expiration)
+	})
+}
+
+func (self *memcached) SetMultiContext(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	return self.setMulti(ctx, items, expiration)
+}
+
+func (self *memcached) AddMultiContext(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	return self.addMulti(ctx, items, expiration)
+}
+
+func (self *memcached) DeleteMultiContext(ctx context.Context, keys []string) error {
+	return self.deleteMulti(ctx, keys)
+}
+
+func (self *memcached) StatsContext(ctx context.Context, args string) (stats map[string]ServerStats, err error) {
+	err = self.withContext(ctx, func() (err error) {
+		stats, err = self.Stats(args)
+		return
+	})
+	return
+}
+
+func (self *memcached) VersionsContext(ctx context.Context) (versions map[string]string, err error) {
+	err = self.withContext(ctx, func() (err error) {
+		versions, err = self.Versions()
+		return
+	})
+	return
+}
+
+func (self *memcached) PingContext(ctx context.Context) (res map[string]PingResult, err error) {
+	err = self.withContext(ctx, func() (err error) {
+		res, err = self.Ping()
+		return
+	})
+	return
+}
+
+func (self *memcachedPool) IncrementContext(ctx context.Context, key string, offset uint32) (value uint64, err error) {
+	err = self.withContext(ctx, func(conn *memcached) (err error) {
+		value, err = conn.Increment(key, offset)
+		return
+	})
+	return
+}
+
+func (self *memcachedPool) DecrementContext(ctx context.Context, key string, offset uint32) (value uint64, err error) {
+	err = self.withContext(ctx, func(conn *memcached) (err error) {
+		value, err = conn.Decrement(key, offset)
+		return
+	})
+	return
+}
+
+func (self *memcachedPool) IncrementWithInitialContext(ctx context.Context, key string, offset, initial uint64, expiration time.Duration) (value uint64, err error) {
+	err = self.withContext(ctx, func(conn *memcached) (err error) {
+		value, err = conn.IncrementWithInitial(key, offset, initial, expiration)
+		return
+	})
+	return
+}
+
+func (self *memcachedPool) DecrementWithInitialContext(ctx context.Context, key string, offset, initial uint64, expiration time.Duration) (value uint64, err error) {
+	err = self.withContext(ctx, func(conn *memcached) (err error) {
+		value, err = conn.DecrementWithInitial(key, offset, initial, expiration)
+		return
+	})
+	return
+}
+
+func (self *memcachedPool) DeleteContext(ctx context.Context, key string, expiration time.Duration) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.Delete(key, expiration)
+	})
+}
+
+func (self *memcachedPool) ExistContext(ctx context.Context, key string) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.Exist(key)
+	})
+}
+
+func (self *memcachedPool) TouchContext(ctx context.Context, key string, expiration time.Duration) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.Touch(key, expiration)
+	})
+}
+
+func (self *memcachedPool) TouchMultiContext(ctx context.Context, keys []string, expiration time.Duration) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.TouchMulti(keys, expiration)
+	})
+}
+
+func (self *memcachedPool) FlushContext(ctx context.Context, expiration time.Duration) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.Flush(expiration)
+	})
+}
+
+func (self *memcachedPool) GetContext(ctx context.Context, key string, value interface{}) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.Get(key, value)
+	})
+}
+
+func (self *memcachedPool) GetAndTouchContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.GetAndTouch(key, value, expiration)
+	})
+}
+
+func (self *memcachedPool) GetMultiContext(ctx context.Context, keys []string) (res Result, err error) {
+	err = self.withContext(ctx, func(conn *memcached) (err error) {
+		res, err = conn.GetMulti(keys)
+		return
+	})
+	return
+}
+
+func (self *memcachedPool) GetMultiFuncContext(ctx context.Context, keys []string, fn func(*Item) error) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.GetMultiFunc(keys, fn)
+	})
+}
+
+func (self *memcachedPool) GetWithCASContext(ctx context.Context, key string, value interface{}) (cas uint64, err error) {
+	err = self.withContext(ctx, func(conn *memcached) (err error) {
+		cas, err = conn.GetWithCAS(key, value)
+		return
+	})
+	return
+}
+
+func (self *memcachedPool) AddContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.Add(key, value, expiration)
+	})
+}
+
+func (self *memcachedPool) ReplaceContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.Replace(key, value, expiration)
+	})
+}
+
+func (self *memcachedPool) SetContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.Set(key, value, expiration)
+	})
+}
+
+func (self *memcachedPool) AppendContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.Append(key, value, expiration)
+	})
+}
+
+func (self *memcachedPool) PrependContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.Prepend(key, value, expiration)
+	})
+}
+
+func (self *memcachedPool) CompareAndSwapContext(ctx context.Context, key string, value interface{}, cas uint64, expiration time.Duration) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.CompareAndSwap(key, value, cas, expiration)
+	})
+}
+
+func (self *memcachedPool) SetMultiContext(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.setMulti(ctx, items, expiration)
+	})
+}
+
+func (self *memcachedPool) AddMultiContext(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.addMulti(ctx, items, expiration)
+	})
+}
+
+func (self *memcachedPool) DeleteMultiContext(ctx context.Context, keys []string) error {
+	return self.withContext(ctx, func(conn *memcached) error {
+		return conn.deleteMulti(ctx, keys)
+	})
+}
+
+func (self *memcachedPool) StatsContext(ctx context.Context, args string) (stats map[string]ServerStats, err error) {
+	err = self.withContext(ctx, func(conn *memcached) (err error) {
+		stats, err = conn.Stats(args)
+		return
+	})
+	return
+}
+
+func (self *memcachedPool) VersionsContext(ctx context.Context) (versions map[string]string, err error) {
+	err = self.withContext(ctx, func(conn *memcached) (err error) {
+		versions, err = conn.Versions()
+		return
+	})
+	return
+}
+
+func (self *memcachedPool) PingContext(ctx context.Context) (res map[string]PingResult, err error) {
+	err = self.withContext(ctx, func(conn *memcached) (err error) {
+		res, err = conn.Ping()
+		return
+	})
+	return
+}
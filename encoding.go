@@ -5,7 +5,9 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"errors"
+	"math/bits"
 	"strconv"
+	"sync"
 )
 
 type EncodingType uint
@@ -16,30 +18,103 @@ const (
 	_NUMERIC_BASE = 10
 )
 
+// A value is stored with the flag bit of its encoding set, bit n for the
+// EncodingType n. Bits 0 to 7 are kept for the encodings of gomc, and bits 8
+// to 31 are left to RegisterCodec, from ENCODING_USER on.
 const (
 	ENCODING_DEFAULT EncodingType = iota
 	ENCODING_GOB
 	ENCODING_JSON
+
+	ENCODING_USER EncodingType = 8
+	_ENCODING_MAX EncodingType = 32
 )
 
 var (
-	encoders = map[EncodingType]EncodeFunc{
-		ENCODING_DEFAULT: encodeDefault,
-		ENCODING_GOB:     encodeGob,
-		ENCODING_JSON:    json.Marshal,
-	}
+	ErrNotAppendable = errors.New("Value encoded by a codec can not be appended or prepended")
+	ErrCodecExists   = errors.New("Codec is already registered for encoding")
+	ErrInvalidCodec  = errors.New("Codec needs an encoding from ENCODING_USER to 31 and both functions")
+	ErrUnknownCodec  = errors.New("Value flagged with an encoding without a registered codec")
+)
+
+type codec struct {
+	encode EncodeFunc
+	decode DecodeFunc
+}
 
-	decoders = map[EncodingType]DecodeFunc{
-		ENCODING_DEFAULT: decodeDefault,
-		ENCODING_GOB:     decodeGob,
-		ENCODING_JSON:    json.Unmarshal,
+var (
+	codecsLock sync.RWMutex
+	codecs     = map[EncodingType]codec{
+		ENCODING_DEFAULT: {encodeDefault, decodeDefault},
+		ENCODING_GOB:     {encodeGob, decodeGob},
+		ENCODING_JSON:    {json.Marshal, json.Unmarshal},
 	}
 )
 
+// RegisterCodec adds an encoding for the clients to use, chosen as any
+// other with NewClient or Options.Encoding. Values it encoded are stored with
+// the flag bit of encoding, so that every client registering the same codec
+// under the same encoding can read them. Values of base types keep the
+// default encoding.
+func RegisterCodec(encoding EncodingType, encoder EncodeFunc, decoder DecodeFunc) error {
+	if encoding < ENCODING_USER || encoding >= _ENCODING_MAX || encoder == nil || decoder == nil {
+		return ErrInvalidCodec
+	}
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+
+	if _, ok := codecs[encoding]; ok {
+		return ErrCodecExists
+	}
+	codecs[encoding] = codec{encode: encoder, decode: decoder}
+	return nil
+}
+
+func lookupCodec(encoding EncodingType) (codec, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	c, ok := codecs[encoding]
+	return c, ok
+}
+
+// flagCodec returns the codec of the lowest flag bit set, the default one
+// without flags, and false when that encoding has no codec registered.
+func flagCodec(flags uint32) (codec, bool) {
+	if flags == 0 {
+		return lookupCodec(ENCODING_DEFAULT)
+	}
+	return lookupCodec(EncodingType(bits.TrailingZeros32(flags)))
+}
+
 func encodingFlag(encoding EncodingType) uint32 {
 	return 1 << encoding
 }
 
+// appendable reports whether flags mark a value of the default encoding, the
+// others, registered in this process or not, being encoded by a codec that
+// appending bytes would corrupt.
+func appendable(flags uint32) bool {
+	return flags&^encodingFlag(ENCODING_DEFAULT) == 0
+}
+
+// checkAppendable checks the flags read back before an append, refusing with
+// ErrNotAppendable to add to a value encoded by a codec, which appending bytes
+// would corrupt whatever encoding the client uses. A missing value is left to
+// the append, which reports it as NOTSTORED.
+func checkAppendable(flags uint32, err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !appendable(flags) {
+		return ErrNotAppendable
+	}
+	return nil
+}
+
 func encodeDefault(object interface{}) (buffer []byte, err error) {
 	switch object.(type) {
 	case bool:
@@ -162,11 +237,16 @@ func decodeGob(buffer []byte, object interface{}) error {
 	return decoder.Decode(object)
 }
 
+// An *Item is stored as is, with its own flags, and reading into an *Item
+// skips decoding, so that values can be encoded outside of the client.
 func encode(object interface{}, encoding EncodingType) (buffer []byte, flag uint32, err error) {
+	if item, ok := object.(*Item); ok {
+		return item.Value, item.Flags, nil
+	}
 	if buffer, err = encodeDefault(object); err == nil {
 		flag = encodingFlag(ENCODING_DEFAULT)
-	} else if encoder, ok := encoders[encoding]; ok {
-		buffer, err = encoder(object)
+	} else if c, ok := lookupCodec(encoding); ok {
+		buffer, err = c.encode(object)
 		flag = encodingFlag(encoding)
 	} else {
 		err = errors.New("Unsupported encoding type")
@@ -175,11 +255,13 @@ func encode(object interface{}, encoding EncodingType) (buffer []byte, flag uint
 }
 
 func decode(buffer []byte, flags uint32, object interface{}) (err error) {
-	for encoding, decoder := range decoders {
-		if flags&encodingFlag(encoding) != 0 {
-			return decoder(buffer, object)
-		}
+	if item, ok := object.(*Item); ok {
+		item.Value, item.Flags = buffer, flags
+		return
 	}
-
-	return decodeDefault(buffer, object)
+	c, ok := flagCodec(flags)
+	if !ok {
+		return ErrUnknownCodec
+	}
+	return c.decode(buffer, object)
 }
@@ -2,6 +2,8 @@ package gomc
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"math/rand"
 	"reflect"
 	"testing"
@@ -205,6 +207,80 @@ func TestStructJSON(t *testing.T) {
 	testStruct(origin, restore, ENCODING_JSON, t)
 }
 
+func TestAppendable(t *testing.T) {
+	if _, flag, _ := encode("log-line", ENCODING_JSON); !appendable(flag) {
+		t.Error("Error appendable:", flag, ", expect: true")
+	}
+	if _, flag, _ := encode(randomStruct(), ENCODING_GOB); appendable(flag) {
+		t.Error("Error appendable:", flag, ", expect: false")
+	}
+	if _, flag, _ := encode(randomStruct(), ENCODING_JSON); appendable(flag) {
+		t.Error("Error appendable:", flag, ", expect: false")
+	}
+}
+
+const (
+	_TEST_ENCODING = ENCODING_USER + 1
+	_TEST_PREFIX   = "test:"
+)
+
+// Registered once for the test binary, the registry being global.
+var testCodecErr = RegisterCodec(_TEST_ENCODING, func(object interface{}) ([]byte, error) {
+	buffer, err := json.Marshal(object)
+	return append([]byte(_TEST_PREFIX), buffer...), err
+}, func(buffer []byte, object interface{}) error {
+	if !bytes.HasPrefix(buffer, []byte(_TEST_PREFIX)) {
+		return errors.New("Missing test prefix")
+	}
+	return json.Unmarshal(buffer[len(_TEST_PREFIX):], object)
+})
+
+func TestRegisterCodec(t *testing.T) {
+	if testCodecErr != nil {
+		t.Fatal("Fail to register codec:", testCodecErr)
+	}
+	if err := RegisterCodec(_TEST_ENCODING, json.Marshal, json.Unmarshal); err != ErrCodecExists {
+		t.Error("Error register twice:", err, ", expect:", ErrCodecExists)
+	}
+	for _, encoding := range []EncodingType{ENCODING_GOB, ENCODING_USER - 1, 32} {
+		if err := RegisterCodec(encoding, json.Marshal, json.Unmarshal); err != ErrInvalidCodec {
+			t.Error("Error register encoding:", encoding, err, ", expect:", ErrInvalidCodec)
+		}
+	}
+	if err := RegisterCodec(ENCODING_USER+2, nil, json.Unmarshal); err != ErrInvalidCodec {
+		t.Error("Error register nil encoder:", err, ", expect:", ErrInvalidCodec)
+	}
+
+	origin := randomStruct()
+	testStruct(origin, new(TestStruct), _TEST_ENCODING, t)
+	buffer, flag, err := encode(origin, _TEST_ENCODING)
+	if err != nil || !bytes.HasPrefix(buffer, []byte(_TEST_PREFIX)) || flag != 1<<9 {
+		t.Error("Error encode:", string(buffer), flag, err)
+	}
+	if appendable(flag) {
+		t.Error("Error appendable:", flag, ", expect: false")
+	}
+	if _, flag, _ = encode("log-line", _TEST_ENCODING); flag != encodingFlag(ENCODING_DEFAULT) {
+		t.Error("Error base type flag:", flag, ", expect:", encodingFlag(ENCODING_DEFAULT))
+	}
+	if err = (&Options{Servers: testHosts, Encoding: _TEST_ENCODING}).validate(); err != nil {
+		t.Error("Error options with registered encoding:", err)
+	}
+
+	// Values of an encoding registered by another process only.
+	unknown := encodingFlag(ENCODING_USER + 5)
+	if appendable(unknown) {
+		t.Error("Error appendable:", unknown, ", expect: false")
+	}
+	var value string
+	if err = decode([]byte("log-line"), unknown, &value); err != ErrUnknownCodec {
+		t.Error("Error decode:", value, err, ", expect:", ErrUnknownCodec)
+	}
+	if err = decode([]byte("log-line"), 0, &value); err != nil || value != "log-line" {
+		t.Error("Error decode without flags:", value, err)
+	}
+}
+
 func BenchmarkEncodeDefault(b *testing.B) {
 	b.StopTimer()
 	origin := randomStr(10)
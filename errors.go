@@ -0,0 +1,59 @@
+package gomc
+
+import (
+	"context"
+)
+
+// Error is returned by every client operation that fails, Code being the
+// libmemcached return code of the failure. Use errors.Is with the sentinel
+// values below to test for a return code. A target with a Message, like
+// ErrServerExists, only matches errors with the same message.
+type Error struct {
+	Code    ReturnType
+	Server  string
+	Message string
+}
+
+var (
+	ErrFailure          = &Error{Code: FAILURE}
+	ErrConnection       = &Error{Code: CONNECTION_FAILURE}
+	ErrNotFound         = &Error{Code: NOTFOUND}
+	ErrNotStored        = &Error{Code: NOTSTORED}
+	ErrDataExists       = &Error{Code: DATA_EXISTS}
+	ErrTimeout          = &Error{Code: TIMEOUT}
+	ErrNoServers        = &Error{Code: NO_SERVERS}
+	ErrServerMarkedDead = &Error{Code: SERVER_MARKED_DEAD}
+	ErrBadKey           = &Error{Code: BAD_KEY_PROVIDED}
+	ErrKeyTooBig        = &Error{Code: KEY_TOO_BIG}
+	ErrNotSupported     = &Error{Code: NOT_SUPPORTED}
+	ErrSomeErrors       = &Error{Code: SOME_ERRORS}
+)
+
+var (
+	ErrCASConflict = ErrDataExists
+)
+
+func (self *Error) Error() string {
+	msg := self.Code.String()
+	if self.Server != "" {
+		msg += " (" + self.Server + ")"
+	}
+	if self.Message != "" && self.Message != self.Code.String() {
+		msg += ": " + self.Message
+	}
+	return msg
+}
+
+func (self *Error) Is(target error) bool {
+	if err, ok := target.(*Error); ok {
+		return err.Code == self.Code && (err.Message == "" || err.Message == self.Message)
+	}
+	return false
+}
+
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
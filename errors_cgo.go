@@ -0,0 +1,46 @@
+//go:build cgo
+
+package gomc
+
+/*
+#include <libmemcached/memcached.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"net"
+	"strconv"
+)
+
+func (self ReturnType) String() string {
+	return C.GoString(C.memcached_strerror(nil, C.memcached_return_t(self)))
+}
+
+func serverName(instance C.memcached_server_instance_st) string {
+	name := C.GoString(C.memcached_server_name(instance))
+	if port := int(C.memcached_server_port(instance)); port != 0 {
+		return net.JoinHostPort(name, strconv.Itoa(port))
+	}
+	return name
+}
+
+func newError(mc *C.memcached_st, returnCode C.memcached_return_t, server string) error {
+	if !C.memcached_failed(returnCode) {
+		return nil
+	}
+
+	err := &Error{Code: ReturnType(returnCode), Server: server}
+	if mc == nil {
+		return err
+	}
+	if C.memcached_last_error(mc) == returnCode {
+		err.Message = C.GoString(C.memcached_last_error_message(mc))
+	}
+	if err.Server == "" {
+		if instance := C.memcached_server_get_last_disconnect(mc); instance != nil {
+			err.Server = serverName(instance)
+		}
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+//go:build !cgo
+
+package gomc
+
+// returnMessages are the messages of memcached_strerror.
+var returnMessages = map[ReturnType]string{
+	SUCCESS:                          "SUCCESS",
+	FAILURE:                          "FAILURE",
+	HOST_LOOKUP_FAILURE:              "getaddrinfo() or getnameinfo() HOSTNAME LOOKUP FAILURE",
+	CONNECTION_FAILURE:               "CONNECTION FAILURE",
+	CONNECTION_BIND_FAILURE:          "CONNECTION BIND FAILURE",
+	WRITE_FAILURE:                    "WRITE FAILURE",
+	READ_FAILURE:                     "READ FAILURE",
+	UNKNOWN_READ_FAILURE:             "UNKNOWN READ FAILURE",
+	PROTOCOL_ERROR:                   "PROTOCOL ERROR",
+	CLIENT_ERROR:                     "CLIENT ERROR",
+	SERVER_ERROR:                     "SERVER ERROR",
+	CONNECTION_SOCKET_CREATE_FAILURE: "CONNECTION SOCKET CREATE FAILURE",
+	DATA_EXISTS:                      "CONNECTION DATA EXISTS",
+	DATA_DOES_NOT_EXIST:              "CONNECTION DATA DOES NOT EXIST",
+	NOTSTORED:                        "NOT STORED",
+	STORED:                           "STORED",
+	NOTFOUND:                         "NOT FOUND",
+	MEMORY_ALLOCATION_FAILURE:        "MEMORY ALLOCATION FAILURE",
+	PARTIAL_READ:                     "PARTIAL READ",
+	SOME_ERRORS:                      "SOME ERRORS WERE REPORTED",
+	NO_SERVERS:                       "NO SERVERS DEFINED",
+	END:                              "SERVER END",
+	DELETED:                          "SERVER DELETE",
+	VALUE:                            "SERVER VALUE",
+	STAT:                             "STAT VALUE",
+	ERRNO:                            "SYSTEM ERROR",
+	FAIL_UNIX_SOCKET:                 "COULD NOT OPEN UNIX SOCKET",
+	NOT_SUPPORTED:                    "ACTION NOT SUPPORTED",
+	NO_KEY_PROVIDED:                  "A KEY LENGTH OF ZERO WAS PROVIDED",
+	FETCH_NOTFINISHED:                "FETCH WAS NOT COMPLETED",
+	TIMEOUT:                          "A TIMEOUT OCCURRED",
+	BUFFERED:                         "ACTION QUEUED",
+	BAD_KEY_PROVIDED:                 "A BAD KEY WAS PROVIDED/CHARACTERS OUT OF RANGE",
+	INVALID_HOST_PROTOCOL:            "THE HOST TRANSPORT PROTOCOL DOES NOT MATCH THAT OF THE CLIENT",
+	SERVER_MARKED_DEAD:               "SERVER IS MARKED DEAD",
+	UNKNOWN_STAT_KEY:                 "ENCOUNTERED AN UNKNOWN STAT KEY",
+	E2BIG:                            "ITEM TOO BIG",
+	INVALID_ARGUMENTS:                "INVALID ARGUMENTS",
+	KEY_TOO_BIG:                      "KEY RETURNED FROM SERVER WAS TOO LARGE",
+	AUTH_PROBLEM:                     "FAILED TO SEND AUTHENTICATION TO SERVER",
+	AUTH_FAILURE:                     "AUTHENTICATION FAILURE",
+	AUTH_CONTINUE:                    "CONTINUE AUTHENTICATION",
+	PARSE_ERROR:                      "ERROR OCCURED WHILE PARSING",
+	PARSE_USER_ERROR:                 "ERROR OCCURED DURING PARSING (user error)",
+	DEPRECATED:                       "DEPRECATED",
+	IN_PROGRESS:                      "OPERATION IN PROCESS",
+	SERVER_TEMPORARILY_DISABLED:      "SERVER HAS FAILED AND IS DISABLED UNTIL TIMED RETRY",
+	SERVER_MEMORY_ALLOCATION_FAILURE: "SERVER FAILED TO ALLOCATE OBJECT",
+}
+
+func (self ReturnType) String() string {
+	if msg, ok := returnMessages[self]; ok {
+		return msg
+	}
+	return "INVALID memcached_return_t"
+}
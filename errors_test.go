@@ -0,0 +1,39 @@
+package gomc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	err := &Error{Code: NOTFOUND, Server: "localhost:11211"}
+	if !errors.Is(err, ErrNotFound) {
+		t.Error("Error is:", err, ", expect:", ErrNotFound)
+	}
+	if errors.Is(err, ErrNotStored) {
+		t.Error("Error is:", err, ", expect not:", ErrNotStored)
+	}
+	if wrapped := fmt.Errorf("get: %w", err); !errors.Is(wrapped, ErrNotFound) {
+		t.Error("Error is:", wrapped, ", expect:", ErrNotFound)
+	}
+	if !errors.Is(ErrCASConflict, ErrDataExists) {
+		t.Error("Error is:", ErrCASConflict, ", expect:", ErrDataExists)
+	}
+
+	var target *Error
+	if !errors.As(ErrServerExists, &target) || target.Code != INVALID_ARGUMENTS {
+		t.Error("Error as:", ErrServerExists, ", expect code:", INVALID_ARGUMENTS)
+	}
+	if errors.Is(ErrServerExists, ErrUnknownServer) {
+		t.Error("Error is:", ErrServerExists, ", expect not:", ErrUnknownServer)
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := &Error{Code: TIMEOUT, Server: "localhost:11211", Message: "poll timed out"}
+	expect := TIMEOUT.String() + " (localhost:11211): poll timed out"
+	if err.Error() != expect {
+		t.Error("Error message:", err.Error(), ", expect:", expect)
+	}
+}
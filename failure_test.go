@@ -0,0 +1,229 @@
+package gomc
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ianoshen/gomc/gomctest"
+)
+
+const (
+	// Long enough for a retry timeout of 1 second to elapse, libmemcached
+	// counting in whole seconds.
+	_TEST_RETRY_WAIT = 2100 * time.Millisecond
+)
+
+// newTestProxies starts n servers behind fault-injecting proxies and a client
+// to the proxies.
+func newTestProxies(t *testing.T, n int) (Client, []*gomctest.Proxy) {
+	cluster := gomctest.Start(t, n)
+	proxies := make([]*gomctest.Proxy, n)
+	servers := make([]string, n)
+	for i, server := range cluster.Servers() {
+		proxies[i] = gomctest.NewProxy(t, server)
+		servers[i] = proxies[i].Addr()
+	}
+	mc, err := newTestClient(servers, ENCODING_DEFAULT)
+	if err != nil {
+		t.Fatal("Fail to new client:", err)
+	}
+	t.Cleanup(mc.Close)
+	return mc, proxies
+}
+
+// keyFor returns a key stored on the server behind proxy.
+func keyFor(t *testing.T, mc Client, proxy *gomctest.Proxy) string {
+	for i := 0; i < 1000; i++ {
+		key := "test-key:" + strconv.Itoa(i)
+		if server, err := mc.ServerForKey(key); err == nil && server.Name() == proxy.Addr() {
+			return key
+		}
+	}
+	t.Fatal("No key for server:", proxy.Addr())
+	return ""
+}
+
+func TestFaults(t *testing.T) {
+	mc, proxies := newTestProxies(t, 1)
+	proxy := proxies[0]
+	if err := mc.SetBehavior(BEHAVIOR_RETRY_TIMEOUT, 0); err != nil {
+		t.Fatal("Fail to set behavior:", err)
+	}
+	if err := mc.SetBehavior(BEHAVIOR_POLL_TIMEOUT, 100); err != nil {
+		t.Fatal("Fail to set behavior:", err)
+	}
+
+	if err := mc.Set("foo", "bar", 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+
+	proxy.SetLatency(300 * time.Millisecond)
+	var value string
+	if err := mc.Get("foo", &value); !errors.Is(err, ErrTimeout) {
+		t.Error("Error get with latency:", err, ", expect:", ErrTimeout)
+	}
+	proxy.Reset()
+
+	proxy.SetFault(gomctest.FAULT_SERVER_ERROR)
+	if err := mc.Set("foo", "baz", 0); !errors.Is(err, &Error{Code: SERVER_ERROR}) {
+		t.Error("Error set with server error:", err)
+	}
+
+	proxy.SetFault(gomctest.FAULT_TRUNCATE)
+	if err := mc.Get("foo", &value); err == nil {
+		t.Error("Error get with truncated reply:", value)
+	}
+
+	proxy.SetFault(gomctest.FAULT_DROP)
+	if err := mc.Get("foo", &value); err == nil {
+		t.Error("Error get with dropped connection:", value)
+	}
+
+	proxy.Reset()
+	if err := mc.Get("foo", &value); err != nil || value != "bar" {
+		t.Error("Error get:", value, err)
+	}
+}
+
+func TestRetryTimeout(t *testing.T) {
+	mc, proxies := newTestProxies(t, 1)
+	proxy := proxies[0]
+	if err := mc.SetBehavior(BEHAVIOR_RETRY_TIMEOUT, 1); err != nil {
+		t.Fatal("Fail to set behavior:", err)
+	}
+
+	proxy.SetFault(gomctest.FAULT_DROP)
+	if err := mc.Set("foo", "bar", 0); err == nil {
+		t.Error("Set should fail with a dropped connection")
+	}
+	proxy.Reset()
+
+	accepted := proxy.Accepted()
+	if err := mc.Set("foo", "bar", 0); err == nil {
+		t.Error("Set should fail until the retry timeout")
+	}
+	if proxy.Accepted() != accepted {
+		t.Error("Server should not be retried before the retry timeout")
+	}
+
+	time.Sleep(_TEST_RETRY_WAIT)
+	if err := mc.Set("foo", "bar", 0); err != nil {
+		t.Error("Fail to set after the retry timeout:", err)
+	}
+}
+
+func TestAutoEjectHosts(t *testing.T) {
+	for _, eject := range []uint64{0, 1} {
+		mc, proxies := newTestProxies(t, 2)
+		if err := mc.SetBehavior(BEHAVIOR_DISTRIBUTION, uint64(DISTRIBUTION_CONSISTENT_KETAMA)); err != nil {
+			t.Fatal("Fail to set behavior:", err)
+		}
+		if err := mc.SetBehavior(BEHAVIOR_RETRY_TIMEOUT, 1); err != nil {
+			t.Fatal("Fail to set behavior:", err)
+		}
+		if err := mc.SetBehavior(BEHAVIOR_SERVER_FAILURE_LIMIT, 2); err != nil {
+			t.Fatal("Fail to set behavior:", err)
+		}
+		if err := mc.SetBehavior(BEHAVIOR_AUTO_EJECT_HOSTS, eject); err != nil {
+			t.Fatal("Fail to set behavior:", err)
+		}
+		key := keyFor(t, mc, proxies[1])
+
+		proxies[1].SetFault(gomctest.FAULT_DROP)
+		for i := 0; i < 2; i++ {
+			if i > 0 {
+				time.Sleep(_TEST_RETRY_WAIT)
+			}
+			if err := mc.Set(key, "bar", 0); err == nil {
+				t.Error("Set should fail with a dropped connection")
+			}
+		}
+
+		// Once the failure limit is reached, the key moves to the other server
+		// with auto eject, and stays on the failed one in timeout otherwise.
+		server, err := mc.ServerForKey(key)
+		if err != nil {
+			t.Error("Fail to get server for key:", err)
+		}
+		if ejected := server.Name() != proxies[1].Addr(); ejected != (eject != 0) {
+			t.Error("Error server for key:", server.Name(), ", auto eject:", eject)
+		}
+		if err = mc.Set(key, "bar", 0); (err == nil) != (eject != 0) {
+			t.Error("Error set:", err, ", auto eject:", eject)
+		}
+
+		proxies[1].Reset()
+		time.Sleep(_TEST_RETRY_WAIT)
+		if server, err = mc.ServerForKey(key); err != nil || server.Name() != proxies[1].Addr() {
+			t.Error("Error server for key after the retry timeout:", server.Name(), err)
+		}
+		if err = mc.Set(key, "bar", 0); err != nil {
+			t.Error("Fail to set after the retry timeout:", err)
+		}
+	}
+}
+
+func TestMultiRoundTrips(t *testing.T) {
+	for _, binary := range []uint64{0, 1} {
+		mc, proxies := newTestProxies(t, 1)
+		if err := mc.SetBehavior(BEHAVIOR_BINARY_PROTOCOL, binary); err != nil {
+			t.Fatal("Fail to set behavior:", err)
+		}
+		items := make(map[string]interface{})
+		keys := make([]string, 0, 20)
+		for i := 0; i < 20; i++ {
+			key := "test-key:" + strconv.Itoa(i)
+			items[key] = i
+			keys = append(keys, key)
+		}
+
+		// Waiting for each reply would take 20 times the latency.
+		proxies[0].SetLatency(100 * time.Millisecond)
+		start := time.Now()
+		if err := mc.SetMulti(items, 0); err != nil {
+			t.Error("Fail to set multi:", err)
+		}
+		if err := mc.DeleteMulti(append(keys, "test-key:missing")); !errors.Is(err, ErrNotFound) {
+			t.Error("Error delete multi:", err, ", expect:", ErrNotFound)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Error("Error batch duration:", elapsed, ", binary protocol:", binary)
+		}
+	}
+}
+
+func TestGetMultiBlackhole(t *testing.T) {
+	mc, proxies := newTestProxies(t, 2)
+	if err := mc.SetBehavior(BEHAVIOR_POLL_TIMEOUT, 200); err != nil {
+		t.Fatal("Fail to set behavior:", err)
+	}
+	if err := mc.SetBehavior(BEHAVIOR_RETRY_TIMEOUT, 0); err != nil {
+		t.Fatal("Fail to set behavior:", err)
+	}
+
+	keys := []string{keyFor(t, mc, proxies[0]), keyFor(t, mc, proxies[1])}
+	for _, key := range keys {
+		if err := mc.Set(key, key, 0); err != nil {
+			t.Error("Fail to set:", err)
+		}
+	}
+
+	// The requests reach no server, so the failure only shows while reading.
+	proxies[1].SetFault(gomctest.FAULT_BLACKHOLE)
+	res, err := mc.GetMulti(keys)
+	if !errors.Is(err, ErrSomeErrors) {
+		t.Fatal("Error get multi:", err, ", expect:", ErrSomeErrors)
+	}
+	var value string
+	if err = res.Get(keys[0], &value); err != nil || value != keys[0] {
+		t.Error("Error value of the healthy server:", value, err)
+	}
+	if err = res.Get(keys[1], &value); err == nil || errors.Is(err, ErrNotFound) {
+		t.Error("Error value of the blackholed server:", err)
+	}
+	if _, ok := res.Errors()[proxies[1].Addr()]; !ok || len(res.Errors()) != 1 {
+		t.Error("Error server errors:", res.Errors())
+	}
+}
@@ -0,0 +1,865 @@
+package gomc
+
+import (
+	"container/list"
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// memcached refuses items above 1MB by default.
+	_FAKE_ITEM_SIZE_MAX = 1024 * 1024
+	// Expirations above 30 days are absolute Unix times for memcached.
+	_FAKE_RELATIVE_EXPIRATION_MAX = 30 * 24 * time.Hour
+
+	_FAKE_VERSION = "1.4.15"
+)
+
+type fakeItem struct {
+	key     string
+	server  string
+	value   []byte
+	flags   uint32
+	cas     uint64
+	stored  time.Time
+	expires time.Time
+	element *list.Element
+}
+
+func (self *fakeItem) size() int {
+	return len(self.key) + len(self.value)
+}
+
+// item returns a copy of the item for key, the key requested.
+func (self *fakeItem) item(key string) *Item {
+	return &Item{
+		Key:   key,
+		Value: append([]byte(nil), self.value...),
+		Flags: self.flags,
+		CAS:   self.cas,
+	}
+}
+
+type fakeCounters struct {
+	cmdGet     uint64
+	cmdSet     uint64
+	getHits    uint64
+	getMisses  uint64
+	evictions  uint64
+	totalItems uint64
+}
+
+// FakeClient is a Client keeping items in memory, for unit tests that should
+// not depend on a memcached process. It follows the behavior of memcached for
+// expirations, CAS tokens and replies, and the encoding of the real clients.
+// Servers are only used to route keys, every item living in the same store,
+// from which the least recently used items are evicted once maxBytes, counted
+// as the size of keys and values, is reached.
+//
+// Expirations are checked against a clock that tests can move with Advance
+// or replace with SetClock.
+type FakeClient struct {
+	encoding EncodingType
+	maxBytes int
+
+	lock     sync.Mutex
+	state    *nativeState
+	items    map[string]*fakeItem
+	lru      *list.List
+	bytes    int
+	cas      uint64
+	clock    func() time.Time
+	offset   time.Duration
+	flushAt  time.Time
+	started  time.Time
+	counters map[string]*fakeCounters
+	closed   bool
+}
+
+// NewFakeClient builds a FakeClient routing keys to servers, as NewClient
+// does. A maxBytes of zero does not limit the size of the store.
+func NewFakeClient(servers []string, maxBytes int, encoding EncodingType) (self *FakeClient, err error) {
+	state, err := newNativeState(parseServers(servers))
+	if err != nil {
+		return
+	}
+	self = &FakeClient{
+		encoding: encoding,
+		maxBytes: maxBytes,
+		state:    state,
+		items:    make(map[string]*fakeItem),
+		lru:      list.New(),
+		clock:    time.Now,
+		counters: make(map[string]*fakeCounters),
+	}
+	self.started = self.now()
+	return
+}
+
+func (self *FakeClient) now() time.Time {
+	return self.clock().Add(self.offset)
+}
+
+// SetClock replaces the clock expirations are checked against, time.Now by
+// default.
+func (self *FakeClient) SetClock(now func() time.Time) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.clock = now
+	self.offset = 0
+}
+
+// Advance moves the clock forward by d.
+func (self *FakeClient) Advance(d time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.offset += d
+}
+
+func (self *FakeClient) counter(server string) *fakeCounters {
+	counters, ok := self.counters[server]
+	if !ok {
+		counters = new(fakeCounters)
+		self.counters[server] = counters
+	}
+	return counters
+}
+
+// expiry converts an expiration as memcached does, in whole seconds and as
+// an absolute time above 30 days.
+func (self *FakeClient) expiry(expiration time.Duration) time.Time {
+	switch {
+	case expiration == 0:
+		return time.Time{}
+	case expiration < 0:
+		return self.now()
+	case expiration > _FAKE_RELATIVE_EXPIRATION_MAX:
+		return time.Unix(int64(expiration/time.Second), 0)
+	}
+	return self.now().Add(expiration.Truncate(time.Second))
+}
+
+func (self *FakeClient) expired(item *fakeItem) bool {
+	now := self.now()
+	if !item.expires.IsZero() && !now.Before(item.expires) {
+		return true
+	}
+	return !self.flushAt.IsZero() && !now.Before(self.flushAt) && !item.stored.After(self.flushAt)
+}
+
+// lookup returns the live item stored under key, dropping it once expired.
+func (self *FakeClient) lookup(key string) *fakeItem {
+	item, ok := self.items[key]
+	if !ok {
+		return nil
+	}
+	if self.expired(item) {
+		self.remove(item)
+		return nil
+	}
+	return item
+}
+
+func (self *FakeClient) remove(item *fakeItem) {
+	self.lru.Remove(item.element)
+	delete(self.items, item.key)
+	self.bytes -= item.size()
+}
+
+func (self *FakeClient) used(item *fakeItem) {
+	self.lru.MoveToFront(item.element)
+}
+
+// put stores item under a new CAS token, evicting the least recently used
+// items to make room for it.
+func (self *FakeClient) put(item *fakeItem) error {
+	if item.size() > _FAKE_ITEM_SIZE_MAX || (self.maxBytes > 0 && item.size() > self.maxBytes) {
+		return &Error{Code: E2BIG, Message: _TEXT_TOO_LARGE}
+	}
+	if previous, ok := self.items[item.key]; ok {
+		self.remove(previous)
+	}
+	self.cas++
+	item.cas = self.cas
+	item.stored = self.now()
+	item.element = self.lru.PushFront(item)
+	self.items[item.key] = item
+	self.bytes += item.size()
+
+	for self.maxBytes > 0 && self.bytes > self.maxBytes {
+		oldest := self.lru.Back().Value.(*fakeItem)
+		self.remove(oldest)
+		if !self.expired(oldest) {
+			self.counter(oldest.server).evictions++
+		}
+	}
+	return nil
+}
+
+// withKey runs fn under the lock with the key as stored, namespace included,
+// and the name of the server key is routed to.
+func (self *FakeClient) withKey(ctx context.Context, key string, fn func(stored, server string) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed {
+		return closedError()
+	}
+	if err := self.state.checkKey(key); err != nil {
+		return err
+	}
+	server, err := self.state.server(key)
+	if err != nil {
+		return err
+	}
+	return serverError(fn(self.state.namespace+key, server.name()), server)
+}
+
+func notFound(key string) error {
+	return &Error{Code: NOTFOUND, Message: "No result for key `" + key + "`"}
+}
+
+func (self *FakeClient) SetBehavior(behavior BehaviorType, value uint64) error {
+	if err := checkBehavior(behavior, value); err != nil {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.state.behaviors[behavior] = value
+	self.state.rebuild()
+	return nil
+}
+
+func (self *FakeClient) GetBehavior(behavior BehaviorType) (uint64, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.state.behaviors[behavior], nil
+}
+
+func (self *FakeClient) AddServer(host string, port int, weight uint32) error {
+	server := newServerConfig(host, port, weight)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.state.findServer(server.name()) >= 0 {
+		return ErrServerExists
+	}
+	self.state.servers = append(self.state.servers, &nativeServer{config: server})
+	self.state.rebuild()
+	return nil
+}
+
+func (self *FakeClient) RemoveServer(host string, port int) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	i := self.state.findServer(newServerConfig(host, port, 0).name())
+	if i < 0 {
+		return ErrUnknownServer
+	}
+	self.state.servers = append(self.state.servers[:i], self.state.servers[i+1:]...)
+	self.state.rebuild()
+	return nil
+}
+
+func (self *FakeClient) SetNamespace(namespace string) error {
+	if err := checkNamespace(namespace); err != nil {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.state.namespace = namespace
+	return nil
+}
+
+func (self *FakeClient) Namespace() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.state.namespace
+}
+
+func (self *FakeClient) Servers() (servers []ServerInfo, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for _, server := range self.state.servers {
+		servers = append(servers, server.info())
+	}
+	return
+}
+
+func (self *FakeClient) GenerateHash(key string) (uint32, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return uint32(self.state.index(key)), nil
+}
+
+func (self *FakeClient) ServerForKey(key string) (info ServerInfo, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	server, err := self.state.server(key)
+	if err != nil {
+		return
+	}
+	return server.info(), nil
+}
+
+func (self *FakeClient) ServersForKeys(keys []string) (groups map[string][]string, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	groups = make(map[string][]string)
+	for _, key := range keys {
+		server, err := self.state.server(key)
+		if err != nil {
+			return nil, err
+		}
+		groups[server.name()] = append(groups[server.name()], key)
+	}
+	return
+}
+
+// incr updates a counter in place as memcached does, increments wrapping
+// around at 64 bits and decrements stopping at zero. A missing counter is
+// created with initial when given.
+func (self *FakeClient) incr(ctx context.Context, key string, decr bool, offset uint64, initial *uint64, expiration time.Duration) (value uint64, err error) {
+	err = self.withKey(ctx, key, func(stored, server string) error {
+		item := self.lookup(stored)
+		if item == nil {
+			if initial == nil {
+				return notFound(key)
+			}
+			value = *initial
+			return self.put(&fakeItem{
+				key:     stored,
+				server:  server,
+				value:   strconv.AppendUint(nil, value, _NUMERIC_BASE),
+				expires: self.expiry(expiration),
+			})
+		}
+
+		current, err := strconv.ParseUint(string(item.value), _NUMERIC_BASE, 64)
+		if err != nil {
+			return &Error{Code: CLIENT_ERROR, Message: "cannot increment or decrement non-numeric value"}
+		}
+		switch {
+		case !decr:
+			value = current + offset
+		case offset < current:
+			value = current - offset
+		}
+		self.bytes -= item.size()
+		item.value = strconv.AppendUint(nil, value, _NUMERIC_BASE)
+		self.bytes += item.size()
+		self.cas++
+		item.cas = self.cas
+		self.used(item)
+		return nil
+	})
+	return
+}
+
+func (self *FakeClient) IncrementContext(ctx context.Context, key string, offset uint32) (uint64, error) {
+	return self.incr(ctx, key, false, uint64(offset), nil, 0)
+}
+
+func (self *FakeClient) DecrementContext(ctx context.Context, key string, offset uint32) (uint64, error) {
+	return self.incr(ctx, key, true, uint64(offset), nil, 0)
+}
+
+// IncrementWithInitialContext and DecrementWithInitialContext work whatever
+// the protocol, unlike the real clients which need the binary one.
+func (self *FakeClient) IncrementWithInitialContext(ctx context.Context, key string, offset, initial uint64, expiration time.Duration) (uint64, error) {
+	return self.incr(ctx, key, false, offset, &initial, expiration)
+}
+
+func (self *FakeClient) DecrementWithInitialContext(ctx context.Context, key string, offset, initial uint64, expiration time.Duration) (uint64, error) {
+	return self.incr(ctx, key, true, offset, &initial, expiration)
+}
+
+// DeleteContext refuses a delay as memcached 1.4 does.
+func (self *FakeClient) DeleteContext(ctx context.Context, key string, expiration time.Duration) error {
+	return self.withKey(ctx, key, func(stored, server string) error {
+		if expiration != 0 {
+			return &Error{Code: CLIENT_ERROR, Message: "bad command line format"}
+		}
+		item := self.lookup(stored)
+		if item == nil {
+			return notFound(key)
+		}
+		self.remove(item)
+		return nil
+	})
+}
+
+func (self *FakeClient) ExistContext(ctx context.Context, key string) error {
+	return self.withKey(ctx, key, func(stored, server string) error {
+		if self.lookup(stored) == nil {
+			return notFound(key)
+		}
+		return nil
+	})
+}
+
+func (self *FakeClient) touch(stored, key string, expiration time.Duration) (*fakeItem, error) {
+	item := self.lookup(stored)
+	if item == nil {
+		return nil, notFound(key)
+	}
+	item.expires = self.expiry(expiration)
+	self.used(item)
+	return item, nil
+}
+
+func (self *FakeClient) TouchContext(ctx context.Context, key string, expiration time.Duration) error {
+	return self.withKey(ctx, key, func(stored, server string) (err error) {
+		_, err = self.touch(stored, key, expiration)
+		return
+	})
+}
+
+func (self *FakeClient) TouchMultiContext(ctx context.Context, keys []string, expiration time.Duration) error {
+	return eachKey(keys, func(key string) error {
+		return self.TouchContext(ctx, key, expiration)
+	})
+}
+
+// FlushContext invalidates every item, after expiration when not zero.
+func (self *FakeClient) FlushContext(ctx context.Context, expiration time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed {
+		return closedError()
+	}
+	if expiration > 0 {
+		self.flushAt = self.expiry(expiration)
+		return nil
+	}
+	self.items = make(map[string]*fakeItem)
+	self.lru.Init()
+	self.bytes = 0
+	self.flushAt = time.Time{}
+	return nil
+}
+
+// get reads a single item, counting the hit or miss.
+func (self *FakeClient) get(stored, server, key string) (*Item, error) {
+	counters := self.counter(server)
+	counters.cmdGet++
+	item := self.lookup(stored)
+	if item == nil {
+		counters.getMisses++
+		return nil, notFound(key)
+	}
+	counters.getHits++
+	self.used(item)
+	return item.item(key), nil
+}
+
+func (self *FakeClient) getItem(ctx context.Context, key string) (item *Item, err error) {
+	err = self.withKey(ctx, key, func(stored, server string) (err error) {
+		item, err = self.get(stored, server, key)
+		return
+	})
+	return
+}
+
+func (self *FakeClient) GetContext(ctx context.Context, key string, value interface{}) error {
+	item, err := self.getItem(ctx, key)
+	if err != nil {
+		return err
+	}
+	return item.Decode(value)
+}
+
+func (self *FakeClient) GetAndTouchContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	var item *Item
+	if err := self.withKey(ctx, key, func(stored, server string) (err error) {
+		if item, err = self.get(stored, server, key); err != nil {
+			return
+		}
+		_, err = self.touch(stored, key, expiration)
+		return
+	}); err != nil {
+		return err
+	}
+	return item.Decode(value)
+}
+
+// getMulti reads every key found, in the order requested.
+func (self *FakeClient) getMulti(ctx context.Context, keys []string) (items []*Item, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed {
+		return nil, closedError()
+	}
+	for _, key := range keys {
+		if err = self.state.checkKey(key); err != nil {
+			return
+		}
+	}
+	for _, key := range keys {
+		server, err := self.state.server(key)
+		if err != nil {
+			return nil, err
+		}
+		if item, err := self.get(self.state.namespace+key, server.name(), key); err == nil {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+func (self *FakeClient) GetMultiContext(ctx context.Context, keys []string) (Result, error) {
+	items, err := self.getMulti(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	res := newResult(keys)
+	for _, item := range items {
+		res.set(item)
+	}
+	return res, nil
+}
+
+// GetMultiFuncContext calls fn once every value has been read, so that fn
+// may use the client.
+func (self *FakeClient) GetMultiFuncContext(ctx context.Context, keys []string, fn func(*Item) error) error {
+	items, err := self.getMulti(ctx, keys)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err = fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *FakeClient) GetWithCASContext(ctx context.Context, key string, value interface{}) (cas uint64, err error) {
+	item, err := self.getItem(ctx, key)
+	if err != nil {
+		return
+	}
+	return item.CAS, item.Decode(value)
+}
+
+// store applies a storage command with the replies of memcached. Appended
+// data keeps the flags and expiration of the item, which must not be encoded
+// by a codec.
+func (self *FakeClient) store(ctx context.Context, command storeCommand, key string, value interface{}, cas uint64, expiration time.Duration) error {
+	buffer, flag, err := encode(value, self.encoding)
+	if err != nil {
+		return err
+	}
+	if (command == _STORE_APPEND || command == _STORE_PREPEND) && !appendable(flag) {
+		return ErrNotAppendable
+	}
+	return self.withKey(ctx, key, func(stored, server string) error {
+		self.counter(server).cmdSet++
+		previous := self.lookup(stored)
+		item := &fakeItem{
+			key:     stored,
+			server:  server,
+			value:   append([]byte(nil), buffer...),
+			flags:   flag,
+			expires: self.expiry(expiration),
+		}
+
+		switch command {
+		case _STORE_ADD:
+			if previous != nil {
+				return &Error{Code: NOTSTORED, Message: _TEXT_NOT_STORED}
+			}
+		case _STORE_REPLACE:
+			if previous == nil {
+				return &Error{Code: NOTSTORED, Message: _TEXT_NOT_STORED}
+			}
+		case _STORE_APPEND, _STORE_PREPEND:
+			if previous == nil {
+				return &Error{Code: NOTSTORED, Message: _TEXT_NOT_STORED}
+			}
+			if !appendable(previous.flags) {
+				return ErrNotAppendable
+			}
+			if command == _STORE_APPEND {
+				item.value = append(append([]byte(nil), previous.value...), buffer...)
+			} else {
+				item.value = append(item.value, previous.value...)
+			}
+			item.flags, item.expires = previous.flags, previous.expires
+		case _STORE_CAS:
+			if previous == nil {
+				return notFound(key)
+			}
+			if previous.cas != cas {
+				return &Error{Code: DATA_EXISTS, Message: _TEXT_EXISTS}
+			}
+		}
+
+		if err := self.put(item); err != nil {
+			return err
+		}
+		self.counter(server).totalItems++
+		return nil
+	})
+}
+
+func (self *FakeClient) AddContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.store(ctx, _STORE_ADD, key, value, 0, expiration)
+}
+
+func (self *FakeClient) ReplaceContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.store(ctx, _STORE_REPLACE, key, value, 0, expiration)
+}
+
+func (self *FakeClient) SetContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.store(ctx, _STORE_SET, key, value, 0, expiration)
+}
+
+func (self *FakeClient) AppendContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.store(ctx, _STORE_APPEND, key, value, 0, expiration)
+}
+
+func (self *FakeClient) PrependContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.store(ctx, _STORE_PREPEND, key, value, 0, expiration)
+}
+
+func (self *FakeClient) CompareAndSwapContext(ctx context.Context, key string, value interface{}, cas uint64, expiration time.Duration) error {
+	return self.store(ctx, _STORE_CAS, key, value, cas, expiration)
+}
+
+func (self *FakeClient) SetMultiContext(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	return eachKey(itemKeys(items), func(key string) error {
+		return self.SetContext(ctx, key, items[key], expiration)
+	})
+}
+
+func (self *FakeClient) AddMultiContext(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	return eachKey(itemKeys(items), func(key string) error {
+		return self.AddContext(ctx, key, items[key], expiration)
+	})
+}
+
+func (self *FakeClient) DeleteMultiContext(ctx context.Context, keys []string) error {
+	return eachKey(keys, func(key string) error {
+		return self.DeleteContext(ctx, key, 0)
+	})
+}
+
+// withServers runs fn under the lock for every server.
+func (self *FakeClient) withServers(ctx context.Context, fn func(server string)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed {
+		return closedError()
+	}
+	for _, server := range self.state.servers {
+		fn(server.name())
+	}
+	return nil
+}
+
+// StatsContext reports the general statistics, as `stats` does, with the
+// items and counters of the keys routed to each server. Only the "settings"
+// group is known besides.
+func (self *FakeClient) StatsContext(ctx context.Context, args string) (stats map[string]ServerStats, err error) {
+	if args != "" && args != "settings" {
+		return nil, notSupported("Unknown stats group `" + args + "`")
+	}
+	collector := make(statsCollector)
+	err = self.withServers(ctx, func(server string) {
+		if args == "settings" {
+			collector.add(server, "maxbytes", strconv.Itoa(self.maxBytes))
+			collector.add(server, "item_size_max", strconv.Itoa(_FAKE_ITEM_SIZE_MAX))
+			return
+		}
+
+		items, bytes := 0, 0
+		for _, item := range self.items {
+			if item.server == server && !self.expired(item) {
+				items++
+				bytes += item.size()
+			}
+		}
+		counters := self.counter(server)
+		now := self.now()
+		for key, value := range map[string]uint64{
+			"pid":            uint64(os.Getpid()),
+			"uptime":         uint64(now.Sub(self.started) / time.Second),
+			"time":           uint64(now.Unix()),
+			"threads":        1,
+			"curr_items":     uint64(items),
+			"total_items":    counters.totalItems,
+			"bytes":          uint64(bytes),
+			"limit_maxbytes": uint64(self.maxBytes),
+			"cmd_get":        counters.cmdGet,
+			"cmd_set":        counters.cmdSet,
+			"get_hits":       counters.getHits,
+			"get_misses":     counters.getMisses,
+			"evictions":      counters.evictions,
+		} {
+			collector.add(server, key, strconv.FormatUint(value, _NUMERIC_BASE))
+		}
+		collector.add(server, "version", _FAKE_VERSION)
+	})
+	return collector.result(), err
+}
+
+func (self *FakeClient) VersionsContext(ctx context.Context) (versions map[string]string, err error) {
+	versions = make(map[string]string)
+	err = self.withServers(ctx, func(server string) {
+		versions[server] = _FAKE_VERSION
+	})
+	return
+}
+
+func (self *FakeClient) PingContext(ctx context.Context) (res map[string]PingResult, err error) {
+	res = make(map[string]PingResult)
+	err = self.withServers(ctx, func(server string) {
+		res[server] = PingResult{}
+	})
+	return
+}
+
+// Close drops every item. Every later operation fails.
+func (self *FakeClient) Close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.closed = true
+	self.items = make(map[string]*fakeItem)
+	self.lru.Init()
+	self.bytes = 0
+}
+
+func (self *FakeClient) Increment(key string, offset uint32) (uint64, error) {
+	return self.IncrementContext(context.Background(), key, offset)
+}
+
+func (self *FakeClient) Decrement(key string, offset uint32) (uint64, error) {
+	return self.DecrementContext(context.Background(), key, offset)
+}
+
+func (self *FakeClient) IncrementWithInitial(key string, offset, initial uint64, expiration time.Duration) (uint64, error) {
+	return self.IncrementWithInitialContext(context.Background(), key, offset, initial, expiration)
+}
+
+func (self *FakeClient) DecrementWithInitial(key string, offset, initial uint64, expiration time.Duration) (uint64, error) {
+	return self.DecrementWithInitialContext(context.Background(), key, offset, initial, expiration)
+}
+
+func (self *FakeClient) Delete(key string, expiration time.Duration) error {
+	return self.DeleteContext(context.Background(), key, expiration)
+}
+
+func (self *FakeClient) Exist(key string) error {
+	return self.ExistContext(context.Background(), key)
+}
+
+func (self *FakeClient) Touch(key string, expiration time.Duration) error {
+	return self.TouchContext(context.Background(), key, expiration)
+}
+
+func (self *FakeClient) TouchMulti(keys []string, expiration time.Duration) error {
+	return self.TouchMultiContext(context.Background(), keys, expiration)
+}
+
+func (self *FakeClient) Flush(expiration time.Duration) error {
+	return self.FlushContext(context.Background(), expiration)
+}
+
+func (self *FakeClient) Get(key string, value interface{}) error {
+	return self.GetContext(context.Background(), key, value)
+}
+
+func (self *FakeClient) GetAndTouch(key string, value interface{}, expiration time.Duration) error {
+	return self.GetAndTouchContext(context.Background(), key, value, expiration)
+}
+
+func (self *FakeClient) GetMulti(keys []string) (Result, error) {
+	return self.GetMultiContext(context.Background(), keys)
+}
+
+func (self *FakeClient) GetMultiFunc(keys []string, fn func(*Item) error) error {
+	return self.GetMultiFuncContext(context.Background(), keys, fn)
+}
+
+func (self *FakeClient) GetWithCAS(key string, value interface{}) (uint64, error) {
+	return self.GetWithCASContext(context.Background(), key, value)
+}
+
+func (self *FakeClient) Add(key string, value interface{}, expiration time.Duration) error {
+	return self.AddContext(context.Background(), key, value, expiration)
+}
+
+func (self *FakeClient) Replace(key string, value interface{}, expiration time.Duration) error {
+	return self.ReplaceContext(context.Background(), key, value, expiration)
+}
+
+func (self *FakeClient) Set(key string, value interface{}, expiration time.Duration) error {
+	return self.SetContext(context.Background(), key, value, expiration)
+}
+
+func (self *FakeClient) Append(key string, value interface{}, expiration time.Duration) error {
+	return self.AppendContext(context.Background(), key, value, expiration)
+}
+
+func (self *FakeClient) Prepend(key string, value interface{}, expiration time.Duration) error {
+	return self.PrependContext(context.Background(), key, value, expiration)
+}
+
+func (self *FakeClient) CompareAndSwap(key string, value interface{}, cas uint64, expiration time.Duration) error {
+	return self.CompareAndSwapContext(context.Background(), key, value, cas, expiration)
+}
+
+func (self *FakeClient) SetMulti(items map[string]interface{}, expiration time.Duration) error {
+	return self.SetMultiContext(context.Background(), items, expiration)
+}
+
+func (self *FakeClient) AddMulti(items map[string]interface{}, expiration time.Duration) error {
+	return self.AddMultiContext(context.Background(), items, expiration)
+}
+
+func (self *FakeClient) DeleteMulti(keys []string) error {
+	return self.DeleteMultiContext(context.Background(), keys)
+}
+
+func (self *FakeClient) Stats(args string) (map[string]ServerStats, error) {
+	return self.StatsContext(context.Background(), args)
+}
+
+func (self *FakeClient) Versions() (map[string]string, error) {
+	return self.VersionsContext(context.Background())
+}
+
+func (self *FakeClient) Ping() (map[string]PingResult, error) {
+	return self.PingContext(context.Background())
+}
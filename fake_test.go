@@ -0,0 +1,230 @@
+package gomc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFake(t *testing.T, maxBytes int) *FakeClient {
+	mc, err := NewFakeClient(testHosts, maxBytes, ENCODING_DEFAULT)
+	if err != nil {
+		t.Fatal("Fail to new fake client:", err)
+	}
+	now := time.Unix(1500000000, 0)
+	mc.SetClock(func() time.Time { return now })
+	return mc
+}
+
+func TestFakeExpiration(t *testing.T) {
+	mc := newTestFake(t, 0)
+
+	if err := mc.Set("foo", "bar", 2*time.Second); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	if err := mc.Set("forever", "bar", 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	mc.Advance(time.Second)
+	if err := mc.Exist("foo"); err != nil {
+		t.Error("Fail to exist:", err)
+	}
+	mc.Advance(time.Second)
+	if err := mc.Exist("foo"); !errors.Is(err, ErrNotFound) {
+		t.Error("Error expired:", err, ", expect:", ErrNotFound)
+	}
+
+	if err := mc.Set("foo", "bar", time.Second); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	if err := mc.Touch("foo", time.Hour); err != nil {
+		t.Error("Fail to touch:", err)
+	}
+	mc.Advance(time.Minute)
+	var value string
+	if err := mc.GetAndTouch("foo", &value, -1); err != nil || value != "bar" {
+		t.Error("Error get and touch:", value, err)
+	}
+	if err := mc.Exist("foo"); !errors.Is(err, ErrNotFound) {
+		t.Error("Error touched:", err, ", expect:", ErrNotFound)
+	}
+
+	if err := mc.Set("foo", "bar", time.Duration(time.Unix(1500000000, 0).Add(time.Hour).Unix())*time.Second); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	mc.Advance(time.Hour)
+	if err := mc.Exist("foo"); !errors.Is(err, ErrNotFound) {
+		t.Error("Error absolute expiration:", err, ", expect:", ErrNotFound)
+	}
+
+	if err := mc.Flush(time.Minute); err != nil {
+		t.Error("Fail to flush:", err)
+	}
+	if err := mc.Exist("forever"); err != nil {
+		t.Error("Fail to exist before flush:", err)
+	}
+	mc.Advance(time.Minute)
+	if err := mc.Exist("forever"); !errors.Is(err, ErrNotFound) {
+		t.Error("Error flushed:", err, ", expect:", ErrNotFound)
+	}
+}
+
+func TestFakeCAS(t *testing.T) {
+	mc := newTestFake(t, 0)
+
+	mc.Set("foo", "bar", 0)
+	var value string
+	cas, err := mc.GetWithCAS("foo", &value)
+	if err != nil || value != "bar" {
+		t.Error("Error get with cas:", value, err)
+	}
+	if err = mc.CompareAndSwap("foo", "baz", cas+1, 0); !errors.Is(err, ErrCASConflict) {
+		t.Error("Error compare and swap:", err, ", expect:", ErrCASConflict)
+	}
+	if err = mc.CompareAndSwap("foo", "baz", cas, 0); err != nil {
+		t.Error("Fail to compare and swap:", err)
+	}
+	if err = mc.CompareAndSwap("foo", "qux", cas, 0); !errors.Is(err, ErrCASConflict) {
+		t.Error("Error stale compare and swap:", err, ", expect:", ErrCASConflict)
+	}
+	if err = mc.CompareAndSwap("missing", "qux", cas, 0); !errors.Is(err, ErrNotFound) {
+		t.Error("Error compare and swap missing:", err, ", expect:", ErrNotFound)
+	}
+
+	if err = mc.Add("foo", "qux", 0); !errors.Is(err, ErrNotStored) {
+		t.Error("Error add:", err, ", expect:", ErrNotStored)
+	}
+	if err = mc.Replace("missing", "qux", 0); !errors.Is(err, ErrNotStored) {
+		t.Error("Error replace:", err, ", expect:", ErrNotStored)
+	}
+	if err = mc.Append("foo", "qux", 0); err != nil {
+		t.Error("Fail to append:", err)
+	}
+	if err = mc.Prepend("foo", "<", 0); err != nil {
+		t.Error("Fail to prepend:", err)
+	}
+	if err = mc.Get("foo", &value); err != nil || value != "<bazqux" {
+		t.Error("Error get:", value, err)
+	}
+}
+
+func TestFakeCounters(t *testing.T) {
+	mc := newTestFake(t, 0)
+
+	mc.Set("num", 5, 0)
+	if num, err := mc.Increment("num", 2); err != nil || num != 7 {
+		t.Error("Error increment:", num, err)
+	}
+	if num, err := mc.Decrement("num", 10); err != nil || num != 0 {
+		t.Error("Error decrement:", num, err)
+	}
+	if _, err := mc.Increment("missing", 1); !errors.Is(err, ErrNotFound) {
+		t.Error("Error increment missing:", err, ", expect:", ErrNotFound)
+	}
+	if num, err := mc.IncrementWithInitial("missing", 1, 10, 0); err != nil || num != 10 {
+		t.Error("Error increment with initial:", num, err)
+	}
+	if num, err := mc.IncrementWithInitial("missing", 1, 10, 0); err != nil || num != 11 {
+		t.Error("Error increment with initial:", num, err)
+	}
+	mc.Set("foo", "bar", 0)
+	if _, err := mc.Increment("foo", 1); err == nil {
+		t.Error("Error increment non-numeric value")
+	}
+}
+
+func TestFakeEviction(t *testing.T) {
+	mc := newTestFake(t, 64)
+
+	value := strings.Repeat("x", 20)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := mc.Set(key, value, 0); err != nil {
+			t.Error("Fail to set:", err)
+		}
+	}
+	var got string
+	mc.Get("a", &got)
+	if err := mc.Set("d", value, 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	if err := mc.Exist("b"); !errors.Is(err, ErrNotFound) {
+		t.Error("Error evicted:", err, ", expect:", ErrNotFound)
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if err := mc.Exist(key); err != nil {
+			t.Error("Fail to exist:", key, err)
+		}
+	}
+	if err := mc.Set("big", strings.Repeat("x", 100), 0); !errors.Is(err, &Error{Code: E2BIG}) {
+		t.Error("Error set too large:", err)
+	}
+
+	stats, err := mc.Stats("")
+	if err != nil {
+		t.Error("Fail to stats:", err)
+	}
+	evictions := 0
+	for _, server := range stats {
+		evictions += int(server.Evictions)
+	}
+	if evictions != 1 {
+		t.Error("Error evictions:", evictions, ", expect:", 1)
+	}
+}
+
+func TestFakeNamespace(t *testing.T) {
+	mc := newTestFake(t, 0)
+
+	mc.Set("foo", "bar", 0)
+	if err := mc.SetNamespace("app:"); err != nil {
+		t.Error("Fail to set namespace:", err)
+	}
+	if err := mc.Exist("foo"); !errors.Is(err, ErrNotFound) {
+		t.Error("Error exist in namespace:", err, ", expect:", ErrNotFound)
+	}
+	mc.Set("foo", "baz", 0)
+	res, err := mc.GetMulti([]string{"foo", "missing"})
+	if err != nil || res.Len() != 1 {
+		t.Error("Error get multi:", res, err)
+	} else if missing := res.Missing(); len(missing) != 1 || missing[0] != "missing" {
+		t.Error("Error missing keys:", missing)
+	}
+	if err = mc.Set("foo bar", "baz", 0); !errors.Is(err, ErrBadKey) {
+		t.Error("Error bad key:", err, ", expect:", ErrBadKey)
+	}
+	if err = mc.SetNamespace("bad namespace"); !errors.Is(err, ErrBadKey) {
+		t.Error("Error bad namespace:", err, ", expect:", ErrBadKey)
+	}
+}
+
+func TestFakeEncoding(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	for _, encoding := range []EncodingType{ENCODING_GOB, ENCODING_JSON} {
+		mc, err := NewFakeClient(testHosts, 0, encoding)
+		if err != nil {
+			t.Fatal("Fail to new fake client:", err)
+		}
+		if err = mc.Set("user", user{"foo", 20}, 0); err != nil {
+			t.Error("Fail to set:", err)
+		}
+		var u user
+		if err = mc.Get("user", &u); err != nil || u.Name != "foo" || u.Age != 20 {
+			t.Error("Error get:", u, err)
+		}
+		if err = mc.Append("user", user{"bar", 30}, 0); err != ErrNotAppendable {
+			t.Error("Error append:", err, ", expect:", ErrNotAppendable)
+		}
+		if err = mc.Append("user", "tail", 0); err != ErrNotAppendable {
+			t.Error("Error append string:", err, ", expect:", ErrNotAppendable)
+		}
+		mc.Close()
+		if err = mc.Get("user", &u); err == nil {
+			t.Error("Error get after close")
+		}
+	}
+}
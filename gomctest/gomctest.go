@@ -0,0 +1,262 @@
+// Package gomctest starts memcached servers for tests, on free ports or
+// temporary unix sockets, and stops them once the test is done. Servers can be
+// killed and restarted mid-test to simulate failures.
+package gomctest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	_FLAG_SOCKET       = "-s"
+	_FLAG_TCP_PORT     = "-p"
+	_FLAG_UDP_PORT     = "-U"
+	_FLAG_VERY_VERBOSE = "-vv"
+	_START_LISTENING   = "server listening"
+
+	// Attempts to start a server on a free port, which may be taken by the
+	// time memcached listens on it.
+	_FREE_PORT_ATTEMPTS = 3
+)
+
+var (
+	// Command is the memcached binary run, looked up in PATH.
+	Command = "memcached"
+	// Timeout is how long a server may take to listen.
+	Timeout = 5 * time.Second
+)
+
+// Server is a memcached process listening on a TCP address or a unix socket.
+type Server struct {
+	addr string
+	unix bool
+
+	lock   sync.Mutex
+	cmd    *exec.Cmd
+	exited chan error
+}
+
+// Cluster is a set of servers stopped by the cleanup of the test starting
+// them.
+type Cluster struct {
+	servers []*Server
+}
+
+// Start starts n servers listening on free ports of localhost.
+func Start(t testing.TB, n int) *Cluster {
+	t.Helper()
+	cluster := newCluster(t)
+	for i := 0; i < n; i++ {
+		var err error
+		for attempt := 0; attempt < _FREE_PORT_ATTEMPTS; attempt++ {
+			var port int
+			if port, err = freePort(); err != nil {
+				break
+			}
+			if err = cluster.start("localhost:" + strconv.Itoa(port)); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			t.Fatal("Fail to start memcached:", err)
+		}
+	}
+	return cluster
+}
+
+// StartUnix starts n servers listening on unix sockets in a temporary
+// directory, removed with the sockets once the test is done.
+func StartUnix(t testing.TB, n int) *Cluster {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "gomctest")
+	if err != nil {
+		t.Fatal("Fail to create socket directory:", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	cluster := newCluster(t)
+	for i := 0; i < n; i++ {
+		socket := filepath.Join(dir, "memcached-"+strconv.Itoa(i+1)+".sock")
+		if err = cluster.start(socket); err != nil {
+			t.Fatal("Fail to start memcached:", err)
+		}
+	}
+	return cluster
+}
+
+// StartAt starts a server on each address, a unix socket when it starts with
+// a slash and a host:port otherwise.
+func StartAt(t testing.TB, addrs ...string) *Cluster {
+	t.Helper()
+	cluster := newCluster(t)
+	for _, addr := range addrs {
+		if err := cluster.start(addr); err != nil {
+			t.Fatal("Fail to start memcached:", err)
+		}
+	}
+	return cluster
+}
+
+func newCluster(t testing.TB) *Cluster {
+	cluster := new(Cluster)
+	t.Cleanup(cluster.Stop)
+	return cluster
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (self *Cluster) start(addr string) error {
+	server := &Server{addr: addr, unix: strings.HasPrefix(addr, "/")}
+	if err := server.start(); err != nil {
+		return err
+	}
+	self.servers = append(self.servers, server)
+	return nil
+}
+
+// Servers returns the addresses of the servers, in the form taken by
+// gomc.NewClient.
+func (self *Cluster) Servers() []string {
+	addrs := make([]string, len(self.servers))
+	for i, server := range self.servers {
+		addrs[i] = server.addr
+	}
+	return addrs
+}
+
+// Server returns the i-th server started.
+func (self *Cluster) Server(i int) *Server {
+	return self.servers[i]
+}
+
+// Stop kills every server.
+func (self *Cluster) Stop() {
+	for _, server := range self.servers {
+		server.Kill()
+	}
+}
+
+// Addr returns the address of the server.
+func (self *Server) Addr() string {
+	return self.addr
+}
+
+// Running reports whether the server process is alive.
+func (self *Server) Running() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.cmd == nil {
+		return false
+	}
+	select {
+	case <-self.exited:
+		self.cmd = nil
+		return false
+	default:
+		return true
+	}
+}
+
+// Kill stops the server at once, as a crash would.
+func (self *Server) Kill() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.kill()
+}
+
+// Restart starts the server again on the same address, killing it first if
+// it is still running.
+func (self *Server) Restart() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.kill()
+	return self.start()
+}
+
+func (self *Server) kill() {
+	if self.cmd == nil {
+		return
+	}
+	self.cmd.Process.Kill()
+	<-self.exited
+	self.cmd = nil
+	if self.unix {
+		os.Remove(self.addr)
+	}
+}
+
+func (self *Server) args() []string {
+	if self.unix {
+		return []string{_FLAG_VERY_VERBOSE, _FLAG_SOCKET, self.addr}
+	}
+	_, port, _ := net.SplitHostPort(self.addr)
+	return []string{_FLAG_VERY_VERBOSE, _FLAG_TCP_PORT, port, _FLAG_UDP_PORT, "0"}
+}
+
+// start runs memcached and waits for it to listen. The output is drained for
+// as long as the process runs, so that verbose logging never blocks it.
+func (self *Server) start() error {
+	output := &readyWriter{ready: make(chan struct{})}
+	cmd := exec.Command(Command, self.args()...)
+	cmd.Stderr = output
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	self.cmd, self.exited = cmd, exited
+
+	timer := time.NewTimer(Timeout)
+	defer timer.Stop()
+	select {
+	case <-output.ready:
+		return nil
+	case err := <-exited:
+		self.cmd = nil
+		return fmt.Errorf("memcached on %s exited before listening: %v: %s", self.addr, err, bytes.TrimSpace(output.buffer))
+	case <-timer.C:
+		self.kill()
+		return fmt.Errorf("memcached on %s not listening after %v", self.addr, Timeout)
+	}
+}
+
+// readyWriter closes ready once memcached reports it is listening.
+type readyWriter struct {
+	ready  chan struct{}
+	buffer []byte
+	done   bool
+}
+
+func (self *readyWriter) Write(p []byte) (int, error) {
+	if !self.done {
+		self.buffer = append(self.buffer, p...)
+		if bytes.Contains(self.buffer, []byte(_START_LISTENING)) {
+			self.done = true
+			self.buffer = nil
+			close(self.ready)
+		}
+	}
+	return len(p), nil
+}
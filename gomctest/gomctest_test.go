@@ -0,0 +1,81 @@
+package gomctest
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+func version(server *Server) (string, error) {
+	network := "tcp"
+	if strings.HasPrefix(server.Addr(), "/") {
+		network = "unix"
+	}
+	conn, err := net.Dial(network, server.Addr())
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte("version\r\n")); err != nil {
+		return "", err
+	}
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestStart(t *testing.T) {
+	cluster := Start(t, 3)
+	if servers := cluster.Servers(); len(servers) != 3 {
+		t.Error("Error servers:", servers)
+	}
+	for i := range cluster.Servers() {
+		if line, err := version(cluster.Server(i)); err != nil || !strings.HasPrefix(line, "VERSION") {
+			t.Error("Error version:", line, err)
+		}
+	}
+}
+
+func TestStartUnix(t *testing.T) {
+	cluster := StartUnix(t, 2)
+	for i := range cluster.Servers() {
+		if line, err := version(cluster.Server(i)); err != nil || !strings.HasPrefix(line, "VERSION") {
+			t.Error("Error version:", line, err)
+		}
+	}
+}
+
+func TestKillRestart(t *testing.T) {
+	cluster := Start(t, 2)
+	server := cluster.Server(1)
+
+	server.Kill()
+	if server.Running() {
+		t.Error("Server should be stopped")
+	}
+	if _, err := version(server); err == nil {
+		t.Error("Killed server should refuse connections")
+	}
+	if line, err := version(cluster.Server(0)); err != nil || !strings.HasPrefix(line, "VERSION") {
+		t.Error("Error version of other server:", line, err)
+	}
+
+	if err := server.Restart(); err != nil {
+		t.Error("Fail to restart:", err)
+	}
+	if !server.Running() {
+		t.Error("Server should be running")
+	}
+	if line, err := version(server); err != nil || !strings.HasPrefix(line, "VERSION") {
+		t.Error("Error version after restart:", line, err)
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	cluster := Start(t, 1)
+	server := &Server{addr: cluster.Server(0).Addr()}
+	if err := server.start(); err == nil {
+		server.Kill()
+		t.Error("Server on a taken port should fail to start")
+	}
+}
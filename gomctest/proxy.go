@@ -0,0 +1,332 @@
+package gomctest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Fault int
+
+const (
+	// FAULT_NONE forwards the traffic untouched.
+	FAULT_NONE Fault = iota
+	// FAULT_DROP closes every connection, the new ones as soon as accepted.
+	FAULT_DROP
+	// FAULT_BLACKHOLE swallows the traffic both ways, the connections staying
+	// open.
+	FAULT_BLACKHOLE
+	// FAULT_TRUNCATE cuts the next reply in half, then closes the connection.
+	FAULT_TRUNCATE
+	// FAULT_SERVER_ERROR answers every request with a server error, without
+	// forwarding it.
+	FAULT_SERVER_ERROR
+)
+
+const (
+	_PROXY_BUFFER_SIZE = 32 * 1024
+
+	_SERVER_ERROR = "SERVER_ERROR injected fault"
+
+	_BINARY_HEADER_SIZE     = 24
+	_BINARY_REQUEST_MAGIC   = 0x80
+	_BINARY_RESPONSE_MAGIC  = 0x81
+	_BINARY_STATUS_INTERNAL = 0x0084
+)
+
+// Proxy forwards connections to a memcached server, injecting latency and
+// faults on demand so that tests can see how a client copes with a failing
+// server.
+type Proxy struct {
+	target   string
+	listener net.Listener
+
+	lock     sync.Mutex
+	fault    Fault
+	latency  time.Duration
+	conns    map[*proxyConn]struct{}
+	accepted int
+	closed   bool
+}
+
+// NewProxy starts a proxy to target, a host:port or a unix socket, listening
+// on a free port of localhost. It is closed once the test is done.
+func NewProxy(t testing.TB, target string) *Proxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal("Fail to listen:", err)
+	}
+	self := &Proxy{
+		target:   target,
+		listener: listener,
+		conns:    make(map[*proxyConn]struct{}),
+	}
+	t.Cleanup(self.Close)
+	go self.serve()
+	return self
+}
+
+// Addr returns the address clients connect to in place of the server.
+func (self *Proxy) Addr() string {
+	return "localhost:" + strconv.Itoa(self.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Accepted returns the number of connections accepted so far.
+func (self *Proxy) Accepted() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.accepted
+}
+
+// SetLatency delays every reply by latency.
+func (self *Proxy) SetLatency(latency time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.latency = latency
+}
+
+// SetFault injects fault until another one is set. FAULT_DROP closes the
+// open connections at once.
+func (self *Proxy) SetFault(fault Fault) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.fault = fault
+	if fault == FAULT_DROP {
+		for conn := range self.conns {
+			conn.close()
+		}
+	}
+}
+
+// Reset forwards the traffic untouched again.
+func (self *Proxy) Reset() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.fault = FAULT_NONE
+	self.latency = 0
+}
+
+// Close stops accepting connections and closes the open ones.
+func (self *Proxy) Close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.closed {
+		return
+	}
+	self.closed = true
+	self.listener.Close()
+	for conn := range self.conns {
+		conn.close()
+	}
+}
+
+func (self *Proxy) settings() (Fault, time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.fault, self.latency
+}
+
+func (self *Proxy) serve() {
+	for {
+		client, err := self.listener.Accept()
+		if err != nil {
+			return
+		}
+		go self.handle(client)
+	}
+}
+
+func (self *Proxy) handle(client net.Conn) {
+	conn := &proxyConn{proxy: self, client: client}
+	self.lock.Lock()
+	self.accepted++
+	if self.closed || self.fault == FAULT_DROP {
+		self.lock.Unlock()
+		client.Close()
+		return
+	}
+	self.conns[conn] = struct{}{}
+	self.lock.Unlock()
+	defer self.remove(conn)
+
+	network := "tcp"
+	if strings.HasPrefix(self.target, "/") {
+		network = "unix"
+	}
+	server, err := net.Dial(network, self.target)
+	if err != nil {
+		conn.close()
+		return
+	}
+	conn.setServer(server)
+
+	go conn.replies()
+	conn.requests()
+}
+
+func (self *Proxy) remove(conn *proxyConn) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	conn.close()
+	delete(self.conns, conn)
+}
+
+// proxyConn pairs a client connection with the one to the server.
+type proxyConn struct {
+	proxy  *Proxy
+	client net.Conn
+
+	lock   sync.Mutex
+	server net.Conn
+}
+
+func (self *proxyConn) setServer(server net.Conn) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.server = server
+}
+
+func (self *proxyConn) close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.client.Close()
+	if self.server != nil {
+		self.server.Close()
+	}
+}
+
+// requests forwards the requests of the client, or answers them itself with
+// FAULT_SERVER_ERROR. The fault is read once a request arrives, so that it
+// applies to the connections already waiting for one.
+func (self *proxyConn) requests() {
+	reader := bufio.NewReaderSize(self.client, _PROXY_BUFFER_SIZE)
+	buffer := make([]byte, _PROXY_BUFFER_SIZE)
+	for {
+		if _, err := reader.Peek(1); err != nil {
+			return
+		}
+		fault, _ := self.proxy.settings()
+		switch fault {
+		case FAULT_SERVER_ERROR:
+			if err := self.serverError(reader); err != nil {
+				return
+			}
+			continue
+		case FAULT_DROP:
+			return
+		}
+
+		n, err := reader.Read(buffer)
+		if err != nil {
+			return
+		}
+		if fault == FAULT_BLACKHOLE {
+			continue
+		}
+		if _, err = self.server.Write(buffer[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// replies forwards the replies of the server, applying the latency and the
+// faults of the proxy.
+func (self *proxyConn) replies() {
+	defer self.close()
+
+	buffer := make([]byte, _PROXY_BUFFER_SIZE)
+	for {
+		n, err := self.server.Read(buffer)
+		if err != nil {
+			return
+		}
+		fault, latency := self.proxy.settings()
+		if latency > 0 {
+			time.Sleep(latency)
+		}
+		switch fault {
+		case FAULT_BLACKHOLE:
+			continue
+		case FAULT_TRUNCATE:
+			self.client.Write(buffer[:n/2])
+			return
+		case FAULT_DROP:
+			return
+		}
+		if _, err = self.client.Write(buffer[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// serverError reads a request and answers it with an error, in the protocol
+// of the request.
+func (self *proxyConn) serverError(reader *bufio.Reader) error {
+	magic, err := reader.Peek(1)
+	if err != nil {
+		return err
+	}
+	if magic[0] == _BINARY_REQUEST_MAGIC {
+		return self.binaryError(reader)
+	}
+	return self.textError(reader)
+}
+
+func (self *proxyConn) textError(reader *bufio.Reader) error {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	// The data block of a storage command is part of the request.
+	fields := strings.Fields(line)
+	if len(fields) >= 5 {
+		switch fields[0] {
+		case "set", "add", "replace", "append", "prepend", "cas":
+			size, err := strconv.Atoi(fields[4])
+			if err != nil {
+				break
+			}
+			if _, err = reader.Discard(size + 2); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = io.WriteString(self.client, _SERVER_ERROR+"\r\n")
+	return err
+}
+
+// binaryError answers a request of the binary protocol with an internal
+// error, quiet requests included, the header keeping the opcode, opaque and
+// CAS of the request.
+func (self *proxyConn) binaryError(reader *bufio.Reader) error {
+	header := make([]byte, _BINARY_HEADER_SIZE)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return err
+	}
+	if _, err := reader.Discard(int(binary.BigEndian.Uint32(header[8:12]))); err != nil {
+		return err
+	}
+
+	header[0] = _BINARY_RESPONSE_MAGIC
+	header[2], header[3], header[4], header[5] = 0, 0, 0, 0
+	binary.BigEndian.PutUint16(header[6:8], _BINARY_STATUS_INTERNAL)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(_SERVER_ERROR)))
+	_, err := self.client.Write(append(header, _SERVER_ERROR...))
+	return err
+}
@@ -0,0 +1,99 @@
+package gomctest
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func request(addr, command string, timeout time.Duration) (string, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err = conn.Write([]byte(command)); err != nil {
+		return "", err
+	}
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestProxy(t *testing.T) {
+	cluster := Start(t, 1)
+	proxy := NewProxy(t, cluster.Servers()[0])
+
+	if line, err := request(proxy.Addr(), "set foo 0 0 3\r\nbar\r\n", time.Second); err != nil || line != "STORED\r\n" {
+		t.Error("Error set:", line, err)
+	}
+	if accepted := proxy.Accepted(); accepted != 1 {
+		t.Error("Error accepted:", accepted, ", expect:", 1)
+	}
+
+	proxy.SetLatency(200 * time.Millisecond)
+	start := time.Now()
+	if line, err := request(proxy.Addr(), "version\r\n", time.Second); err != nil || !strings.HasPrefix(line, "VERSION") {
+		t.Error("Error version:", line, err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Error("Error latency:", elapsed)
+	}
+	proxy.Reset()
+
+	proxy.SetFault(FAULT_SERVER_ERROR)
+	if line, err := request(proxy.Addr(), "set foo 0 0 3\r\nbaz\r\n", time.Second); err != nil || line != _SERVER_ERROR+"\r\n" {
+		t.Error("Error server error:", line, err)
+	}
+
+	proxy.SetFault(FAULT_BLACKHOLE)
+	if line, err := request(proxy.Addr(), "version\r\n", 200*time.Millisecond); err == nil {
+		t.Error("Error blackhole:", line)
+	}
+
+	proxy.SetFault(FAULT_TRUNCATE)
+	if line, err := request(proxy.Addr(), "get foo\r\n", time.Second); err == nil || strings.HasSuffix(line, "\n") {
+		t.Error("Error truncate:", line, err)
+	}
+
+	proxy.SetFault(FAULT_DROP)
+	if line, err := request(proxy.Addr(), "version\r\n", time.Second); err == nil {
+		t.Error("Error drop:", line)
+	}
+
+	proxy.Reset()
+	if line, err := request(proxy.Addr(), "get foo\r\n", time.Second); err != nil || !strings.HasPrefix(line, "VALUE foo 0 3") {
+		t.Error("Error get:", line, err)
+	}
+}
+
+func TestProxyOpenConn(t *testing.T) {
+	cluster := Start(t, 1)
+	proxy := NewProxy(t, cluster.Servers()[0])
+
+	conn, err := net.Dial("tcp", proxy.Addr())
+	if err != nil {
+		t.Fatal("Fail to dial:", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	if _, err = conn.Write([]byte("version\r\n")); err != nil {
+		t.Error("Fail to write:", err)
+	}
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, "VERSION") {
+		t.Error("Error version:", line, err)
+	}
+
+	// The connection waits for its next request when the fault is set.
+	proxy.SetFault(FAULT_SERVER_ERROR)
+	if _, err = conn.Write([]byte("set foo 0 0 3\r\nbar\r\n")); err != nil {
+		t.Error("Fail to write:", err)
+	}
+	if line, err := reader.ReadString('\n'); err != nil || line != _SERVER_ERROR+"\r\n" {
+		t.Error("Error server error on open connection:", line, err)
+	}
+}
@@ -1,33 +1,136 @@
 package gomc
 
 import (
+	"context"
+	"errors"
 	"time"
 )
 
+var (
+	ErrPoolExhausted = errors.New("No free connection in pool")
+)
+
+type ReturnType int
+type BehaviorType int
+type DistributionType int
+type HashType int
+type ConnectionType int
+
+// Result holds the values found by GetMulti. Looking up a key that was not
+// found returns an error matching ErrNotFound, or the error of its server
+// when that server failed.
 type Result interface {
 	Get(string, interface{}) error
+	CAS(string) (uint64, error)
+	Item(string) (*Item, error)
+	Has(string) bool
+	Len() int
+	Keys() []string
+	Missing() []string
+	Errors() map[string]error
+}
+
+// ContextClient holds the variants of the Client operations bounded by a
+// context. Its deadline caps the poll timeout of the call and, for a pool,
+// the wait for a free connection. With libmemcached, cancelling a context
+// without a deadline can not interrupt a call already running, only keep the
+// next ones from starting; the native backend interrupts it.
+type ContextClient interface {
+	IncrementContext(context.Context, string, uint32) (uint64, error)
+	DecrementContext(context.Context, string, uint32) (uint64, error)
+	IncrementWithInitialContext(context.Context, string, uint64, uint64, time.Duration) (uint64, error)
+	DecrementWithInitialContext(context.Context, string, uint64, uint64, time.Duration) (uint64, error)
+	DeleteContext(context.Context, string, time.Duration) error
+	ExistContext(context.Context, string) error
+	TouchContext(context.Context, string, time.Duration) error
+	TouchMultiContext(context.Context, []string, time.Duration) error
+	FlushContext(context.Context, time.Duration) error
+	GetContext(context.Context, string, interface{}) error
+	GetAndTouchContext(context.Context, string, interface{}, time.Duration) error
+	GetMultiContext(context.Context, []string) (Result, error)
+	GetMultiFuncContext(context.Context, []string, func(*Item) error) error
+	GetWithCASContext(context.Context, string, interface{}) (uint64, error)
+	AddContext(context.Context, string, interface{}, time.Duration) error
+	ReplaceContext(context.Context, string, interface{}, time.Duration) error
+	SetContext(context.Context, string, interface{}, time.Duration) error
+	AppendContext(context.Context, string, interface{}, time.Duration) error
+	PrependContext(context.Context, string, interface{}, time.Duration) error
+	CompareAndSwapContext(context.Context, string, interface{}, uint64, time.Duration) error
+	SetMultiContext(context.Context, map[string]interface{}, time.Duration) error
+	AddMultiContext(context.Context, map[string]interface{}, time.Duration) error
+	DeleteMultiContext(context.Context, []string) error
+	StatsContext(context.Context, string) (map[string]ServerStats, error)
+	VersionsContext(context.Context) (map[string]string, error)
+	PingContext(context.Context) (map[string]PingResult, error)
 }
 
 type Client interface {
+	ContextClient
 	SetBehavior(BehaviorType, uint64) error
 	GetBehavior(BehaviorType) (uint64, error)
+	AddServer(string, int, uint32) error
+	RemoveServer(string, int) error
+	SetNamespace(string) error
+	Namespace() string
+	Servers() ([]ServerInfo, error)
 	GenerateHash(string) (uint32, error)
+	ServerForKey(string) (ServerInfo, error)
+	ServersForKeys([]string) (map[string][]string, error)
 	Increment(string, uint32) (uint64, error)
 	Decrement(string, uint32) (uint64, error)
+	IncrementWithInitial(string, uint64, uint64, time.Duration) (uint64, error)
+	DecrementWithInitial(string, uint64, uint64, time.Duration) (uint64, error)
 	Delete(string, time.Duration) error
 	Exist(string) error
+	Touch(string, time.Duration) error
+	TouchMulti([]string, time.Duration) error
 	Flush(time.Duration) error
 	Get(string, interface{}) error
+	GetAndTouch(string, interface{}, time.Duration) error
 	GetMulti([]string) (Result, error)
+	GetMultiFunc([]string, func(*Item) error) error
+	GetWithCAS(string, interface{}) (uint64, error)
 	Add(string, interface{}, time.Duration) error
 	Replace(string, interface{}, time.Duration) error
 	Set(string, interface{}, time.Duration) error
+	Append(string, interface{}, time.Duration) error
+	Prepend(string, interface{}, time.Duration) error
+	CompareAndSwap(string, interface{}, uint64, time.Duration) error
+	SetMulti(map[string]interface{}, time.Duration) error
+	AddMulti(map[string]interface{}, time.Duration) error
+	DeleteMulti([]string) error
+	Stats(string) (map[string]ServerStats, error)
+	Versions() (map[string]string, error)
+	Ping() (map[string]PingResult, error)
 	Close()
 }
 
-func NewClient(servers []string, poolSize int, encoding EncodingType) (self Client, err error) {
+type Pool interface {
+	Client
+	SetCheckoutTimeout(time.Duration)
+	PoolStats() PoolStats
+}
+
+type PoolStats struct {
+	MaxSize  int
+	InUse    int64
+	Waits    uint64
+	Timeouts uint64
+}
+
+func NewClient(servers []string, poolSize int, encoding EncodingType) (Client, error) {
+	configs := parseServers(servers)
 	if poolSize <= 1 {
-		return newMemcached(servers, encoding)
+		return newBackend(BACKEND_DEFAULT, clientConfig(configs), configs, false, 1, encoding)
+	}
+	return newBackend(BACKEND_DEFAULT, poolConfig(configs, 1, poolSize), configs, true, poolSize, encoding)
+}
+
+func NewPool(servers []string, initSize, maxSize int, encoding EncodingType) (Pool, error) {
+	configs := parseServers(servers)
+	client, err := newBackend(BACKEND_DEFAULT, poolConfig(configs, initSize, maxSize), configs, true, maxSize, encoding)
+	if err != nil {
+		return nil, err
 	}
-	return newPool(servers, 1, poolSize, encoding)
+	return client.(Pool), nil
 }
@@ -1,3 +1,5 @@
+//go:build cgo
+
 package gomc
 
 /*
@@ -14,61 +16,93 @@ import (
 	"unsafe"
 )
 
-type ReturnType int
-type BehaviorType int
-type DistributionType int
-type HashType int
-type ConnectionType int
+const (
+	_CONFIG_ERROR_BUFFER_SIZE = 1024
+)
 
 func cString(str string) (*C.char, C.size_t) {
 	return C.CString(str), C.size_t(len(str))
 }
 
 type memcached struct {
-	mc       *C.memcached_st
-	encoding EncodingType
+	mc        *C.memcached_st
+	encoding  EncodingType
+	servers   []serverConfig
+	namespace string
+	pipeline  *pipeline
 }
 
 func newMemcached(servers []string, encoding EncodingType) (self *memcached, err error) {
-	config := clientConfig(servers)
+	configs := parseServers(servers)
+	return newMemcachedWithConfig(clientConfig(configs), configs, encoding)
+}
+
+func newMemcachedWithConfig(config string, servers []serverConfig, encoding EncodingType) (self *memcached, err error) {
 	cs_config, config_len := cString(config)
 	defer C.free(unsafe.Pointer(cs_config))
 
 	self = new(memcached)
 	self.mc = C.memcached(cs_config, config_len)
 	if self.mc == nil {
-		err = self.checkError(
-			C.libmemcached_check_configuration(
-				cs_config, config_len, nil, 0))
+		if err = checkConfiguration(config); err == nil {
+			err = newError(nil, C.memcached_return_t(MEMORY_ALLOCATION_FAILURE), "")
+		}
 		return
 	}
 	self.encoding = encoding
+	self.servers = servers
+	self.namespace = self.readNamespace()
+	self.pipeline = newPipeline(1)
+	err = self.SetBehavior(BEHAVIOR_SUPPORT_CAS, 1)
 	return
 }
 
+// checkConfiguration validates config with libmemcached's own parser, whose
+// message tells where parsing stopped.
+func checkConfiguration(config string) error {
+	cs_config, config_len := cString(config)
+	defer C.free(unsafe.Pointer(cs_config))
+	buffer := (*C.char)(C.calloc(_CONFIG_ERROR_BUFFER_SIZE, 1))
+	defer C.free(unsafe.Pointer(buffer))
+
+	returnCode := C.libmemcached_check_configuration(cs_config, config_len, buffer, _CONFIG_ERROR_BUFFER_SIZE)
+	if C.memcached_failed(returnCode) {
+		return &Error{Code: ReturnType(returnCode), Message: C.GoString(buffer)}
+	}
+	return nil
+}
+
 func (self *memcached) encode(object interface{}) ([]byte, uint32, error) {
 	return encode(object, self.encoding)
 }
 
+func (self *memcached) encodeAppendable(object interface{}) (buffer []byte, flag uint32, err error) {
+	if buffer, flag, err = self.encode(object); err == nil && !appendable(flag) {
+		err = ErrNotAppendable
+	}
+	return
+}
+
 func (self *memcached) checkError(returnCode C.memcached_return_t) error {
-	if C.memcached_failed(returnCode) {
-		return errors.New(C.GoString(C.memcached_strerror(self.mc, returnCode)))
+	return newError(self.mc, returnCode, "")
+}
+
+func (self *memcached) checkKeyError(cs_key *C.char, key_len C.size_t, returnCode C.memcached_return_t) error {
+	if !C.memcached_failed(returnCode) {
+		return nil
 	}
-	return nil
+	rc := new(C.memcached_return_t)
+	server := ""
+	if instance := C.memcached_server_by_key(self.mc, cs_key, key_len, rc); instance != nil {
+		server = serverName(instance)
+	}
+	return newError(self.mc, returnCode, server)
 }
 
 func (self *memcached) LastErrorMessage() string {
 	return C.GoString(C.memcached_last_error_message(self.mc))
 }
 
-func (self *memcached) AddServer(host string, port int, weight uint32) error {
-	cs_host := C.CString(host)
-	defer C.free(unsafe.Pointer(cs_host))
-	return self.checkError(
-		C.memcached_server_add_with_weight(
-			self.mc, cs_host, C.in_port_t(port), C.uint32_t(weight)))
-}
-
 func (self *memcached) SetBehavior(behavior BehaviorType, value uint64) error {
 	return self.checkError(
 		C.memcached_behavior_set(
@@ -87,35 +121,93 @@ func (self *memcached) GenerateHash(key string) (uint32, error) {
 }
 
 func (self *memcached) Increment(key string, offset uint32) (value uint64, err error) {
+	if err = self.checkKey(key); err != nil {
+		return
+	}
 	cs_key, key_len := cString(key)
 	defer C.free(unsafe.Pointer(cs_key))
-	err = self.checkError(
+	err = self.checkKeyError(cs_key, key_len,
 		C.memcached_increment(
 			self.mc, cs_key, key_len, C.uint32_t(offset), (*C.uint64_t)(&value)))
 	return
 }
 
 func (self *memcached) Decrement(key string, offset uint32) (value uint64, err error) {
+	if err = self.checkKey(key); err != nil {
+		return
+	}
 	cs_key, key_len := cString(key)
 	defer C.free(unsafe.Pointer(cs_key))
-	err = self.checkError(
+	err = self.checkKeyError(cs_key, key_len,
 		C.memcached_decrement(
 			self.mc, cs_key, key_len, C.uint32_t(offset), (*C.uint64_t)(&value)))
 	return
 }
 
+// IncrementWithInitial and DecrementWithInitial store initial when the key
+// does not exist yet. libmemcached only supports them with
+// BEHAVIOR_BINARY_PROTOCOL enabled.
+func (self *memcached) IncrementWithInitial(key string, offset, initial uint64, expiration time.Duration) (value uint64, err error) {
+	if err = self.checkKey(key); err != nil {
+		return
+	}
+	cs_key, key_len := cString(key)
+	defer C.free(unsafe.Pointer(cs_key))
+	err = self.checkKeyError(cs_key, key_len,
+		C.memcached_increment_with_initial(
+			self.mc, cs_key, key_len, C.uint64_t(offset), C.uint64_t(initial),
+			C.time_t(expiration.Seconds()), (*C.uint64_t)(&value)))
+	return
+}
+
+func (self *memcached) DecrementWithInitial(key string, offset, initial uint64, expiration time.Duration) (value uint64, err error) {
+	if err = self.checkKey(key); err != nil {
+		return
+	}
+	cs_key, key_len := cString(key)
+	defer C.free(unsafe.Pointer(cs_key))
+	err = self.checkKeyError(cs_key, key_len,
+		C.memcached_decrement_with_initial(
+			self.mc, cs_key, key_len, C.uint64_t(offset), C.uint64_t(initial),
+			C.time_t(expiration.Seconds()), (*C.uint64_t)(&value)))
+	return
+}
+
 func (self *memcached) Delete(key string, expiration time.Duration) error {
+	if err := self.checkKey(key); err != nil {
+		return err
+	}
 	cs_key, key_len := cString(key)
 	defer C.free(unsafe.Pointer(cs_key))
-	return self.checkError(
+	return self.checkKeyError(cs_key, key_len,
 		C.memcached_delete(
 			self.mc, cs_key, key_len, C.time_t(expiration.Seconds())))
 }
 
 func (self *memcached) Exist(key string) error {
+	if err := self.checkKey(key); err != nil {
+		return err
+	}
 	cs_key, key_len := cString(key)
 	defer C.free(unsafe.Pointer(cs_key))
-	return self.checkError(C.memcached_exist(self.mc, cs_key, key_len))
+	return self.checkKeyError(cs_key, key_len, C.memcached_exist(self.mc, cs_key, key_len))
+}
+
+func (self *memcached) Touch(key string, expiration time.Duration) error {
+	if err := self.checkKey(key); err != nil {
+		return err
+	}
+	cs_key, key_len := cString(key)
+	defer C.free(unsafe.Pointer(cs_key))
+	return self.checkKeyError(cs_key, key_len,
+		C.memcached_touch(
+			self.mc, cs_key, key_len, C.time_t(expiration.Seconds())))
+}
+
+func (self *memcached) TouchMulti(keys []string, expiration time.Duration) error {
+	return eachKey(keys, func(key string) error {
+		return self.Touch(key, expiration)
+	})
 }
 
 func (self *memcached) FlushBuffers() error {
@@ -127,6 +219,9 @@ func (self *memcached) Flush(expiration time.Duration) error {
 }
 
 func (self *memcached) Get(key string, value interface{}) (err error) {
+	if err = self.checkKey(key); err != nil {
+		return
+	}
 	flags := new(C.uint32_t)
 	ret := new(C.memcached_return_t)
 	value_len := new(C.size_t)
@@ -136,13 +231,29 @@ func (self *memcached) Get(key string, value interface{}) (err error) {
 	raw := C.memcached_get(self.mc, cs_key, key_len, value_len, flags, ret)
 	defer C.free(unsafe.Pointer(raw))
 	buffer := C.GoBytes(unsafe.Pointer(raw), C.int(*value_len))
-	if err = self.checkError(*ret); err != nil {
+	if err = self.checkKeyError(cs_key, key_len, *ret); err != nil {
 		return
 	}
 	return decode(buffer, uint32(*flags), value)
 }
 
-func (self *memcached) getMulti(keys []string) (res *result, err error) {
+// GetAndTouch is a touch followed by a get, libmemcached 1.0.18 having no
+// get-and-touch call: two round trips, the value possibly changing or
+// expiring in between. The native backend sends a single GAT with the binary
+// protocol.
+func (self *memcached) GetAndTouch(key string, value interface{}, expiration time.Duration) (err error) {
+	if err = self.Touch(key, expiration); err != nil {
+		return
+	}
+	return self.Get(key, value)
+}
+
+// mget sends a multi-get for keys, whose results are then read with fetch.
+func (self *memcached) mget(keys []string) (err error) {
+	if err = self.checkKeys(keys); err != nil {
+		return
+	}
+
 	char_size := unsafe.Sizeof(new(C.char))
 	cs_keys := C.malloc(C.size_t(len(keys)) * C.size_t(char_size))
 	defer C.free(cs_keys)
@@ -163,32 +274,192 @@ func (self *memcached) getMulti(keys []string) (res *result, err error) {
 	}
 
 	ret := C.memcached_mget(self.mc, (**C.char)(cs_keys), (*C.size_t)(key_lens), C.size_t(len(keys)))
-	if err = self.checkError(ret); err != nil {
-		return
-	}
+	return self.checkError(ret)
+}
 
+// fetch reads the results of the last mget into raw, which is reused for
+// each of them, and hands them to fn. Once fn fails the remaining results
+// are still read, so that the connection is left clean, but discarded. A
+// server failing mid-stream is passed to failed with its error, the results
+// of the other servers being read all the same.
+func (self *memcached) fetch(raw *C.memcached_result_st, keys resultKeys, fn func(*Item) error, failed func(server string, err error)) (err error) {
 	rc := new(C.memcached_return_t)
-	res = newResult(len(keys))
+	seen := make(map[string]bool)
 	for {
-		if raw := C.memcached_fetch_result(self.mc, nil, rc); raw != nil && ReturnType(*rc) != END {
-			key := C.memcached_result_key_value(raw)
-			buffer := C.memcached_result_value(raw)
-			buffer_len := C.memcached_result_length(raw)
-			flags := C.memcached_result_flags(raw)
-			res.set(C.GoString(key), C.GoBytes(unsafe.Pointer(buffer), C.int(buffer_len)), uint32(flags))
-			C.memcached_result_free(raw)
-		} else {
-			break
+		if C.memcached_fetch_result(self.mc, raw, rc) == nil {
+			switch ReturnType(*rc) {
+			case SUCCESS, END, NOTFOUND:
+				return
+			}
+			// The failed server is closed and no longer read, so fetching
+			// goes on with the others.
+			e, ok := self.checkError(*rc).(*Error)
+			if !ok || e.Server == "" || seen[e.Server] {
+				return
+			}
+			seen[e.Server] = true
+			failed(e.Server, e)
+			continue
 		}
+		if ReturnType(*rc) == END {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		key := C.memcached_result_key_value(raw)
+		buffer := C.memcached_result_value(raw)
+		buffer_len := C.memcached_result_length(raw)
+		err = fn(&Item{
+			Key:   keys.key(C.GoString(key)),
+			Value: C.GoBytes(unsafe.Pointer(buffer), C.int(buffer_len)),
+			Flags: uint32(C.memcached_result_flags(raw)),
+			CAS:   uint64(C.memcached_result_cas(raw)),
+		})
+	}
+}
+
+func (self *memcached) createResult() (raw *C.memcached_result_st, err error) {
+	if raw = C.memcached_result_create(self.mc, nil); raw == nil {
+		err = self.checkError(C.memcached_return_t(MEMORY_ALLOCATION_FAILURE))
 	}
 	return
 }
 
+// serverErrors records, for a multi-get that returned SOME_ERRORS, the server
+// of each key and the error of the servers that failed.
+func (self *memcached) serverErrors(res *result) {
+	rc := new(C.memcached_return_t)
+	for _, key := range res.keys {
+		cs_key, key_len := cString(key)
+		instance := C.memcached_server_by_key(self.mc, cs_key, key_len, rc)
+		C.free(unsafe.Pointer(cs_key))
+		if instance == nil {
+			continue
+		}
+
+		server := serverName(instance)
+		var err error
+		if returnCode := C.memcached_server_error_return(instance); C.memcached_failed(returnCode) {
+			err = &Error{
+				Code:    ReturnType(returnCode),
+				Server:  server,
+				Message: C.GoString(C.memcached_server_error(instance)),
+			}
+		}
+		res.setServerError(key, server, err)
+	}
+}
+
+func (self *memcached) keyServer(key string) string {
+	cs_key, key_len := cString(key)
+	defer C.free(unsafe.Pointer(cs_key))
+
+	rc := new(C.memcached_return_t)
+	if instance := C.memcached_server_by_key(self.mc, cs_key, key_len, rc); instance != nil {
+		return serverName(instance)
+	}
+	return ""
+}
+
+// failServer records err against server and the keys it holds, for a server
+// failing while its results are read.
+func (self *memcached) failServer(res *result, server string, err error) {
+	for _, key := range res.keys {
+		if self.keyServer(key) == server {
+			res.setServerError(key, server, err)
+		}
+	}
+}
+
+// getMulti still returns what could be read when only some of the servers
+// failed, along with a SOME_ERRORS error, see Result.Errors.
+func (self *memcached) getMulti(keys []string) (res *result, err error) {
+	partial := self.mget(keys)
+	if partial != nil && !errors.Is(partial, ErrSomeErrors) {
+		return nil, partial
+	}
+	res = newResult(keys)
+	if partial != nil {
+		self.serverErrors(res)
+	}
+
+	raw, err := self.createResult()
+	if err != nil {
+		return
+	}
+	defer C.memcached_result_free(raw)
+
+	if err = self.fetch(raw, self.resultKeys(keys), func(item *Item) error {
+		res.set(item)
+		return nil
+	}, func(server string, e error) {
+		self.failServer(res, server, e)
+		partial = &Error{Code: SOME_ERRORS, Message: "Failed to read from " + server}
+	}); err != nil {
+		return
+	}
+	return res, partial
+}
+
+// GetMultiFunc calls fn for each value found among keys as soon as it is
+// read, without holding all of them at once. Keys are requested in batches
+// of 1000. The first error returned by fn stops the iteration and is
+// returned. When only some servers fail, the values of the others are still
+// passed to fn and a SOME_ERRORS error is returned at the end.
+func (self *memcached) GetMultiFunc(keys []string, fn func(*Item) error) (err error) {
+	var partial error
+	raw, err := self.createResult()
+	if err != nil {
+		return
+	}
+	defer C.memcached_result_free(raw)
+
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > _GET_MULTI_BATCH_SIZE {
+			batch = batch[:_GET_MULTI_BATCH_SIZE]
+		}
+		keys = keys[len(batch):]
+
+		if err = self.mget(batch); errors.Is(err, ErrSomeErrors) {
+			partial = err
+		} else if err != nil {
+			return
+		}
+		if err = self.fetch(raw, self.resultKeys(batch), fn, func(string, error) {
+			partial = &Error{Code: SOME_ERRORS}
+		}); err != nil {
+			return
+		}
+	}
+	return partial
+}
+
 func (self *memcached) GetMulti(keys []string) (Result, error) {
-	return self.getMulti(keys)
+	res, err := self.getMulti(keys)
+	if res == nil {
+		return nil, err
+	}
+	return res, err
+}
+
+func (self *memcached) GetWithCAS(key string, value interface{}) (cas uint64, err error) {
+	res, err := self.getMulti([]string{key})
+	if err != nil {
+		return
+	}
+	if cas, err = res.CAS(key); err != nil {
+		return
+	}
+	err = res.Get(key, value)
+	return
 }
 
 func (self *memcached) Add(key string, value interface{}, expiration time.Duration) (err error) {
+	if err = self.checkKey(key); err != nil {
+		return
+	}
 	buffer, flag, err := self.encode(value)
 	if err != nil {
 		return
@@ -198,13 +469,16 @@ func (self *memcached) Add(key string, value interface{}, expiration time.Durati
 	cs_value, value_len := cString(string(buffer))
 	defer C.free(unsafe.Pointer(cs_value))
 
-	return self.checkError(
+	return self.checkKeyError(cs_key, key_len,
 		C.memcached_add(
 			self.mc, cs_key, key_len, cs_value, value_len,
 			C.time_t(expiration.Seconds()), C.uint32_t(flag)))
 }
 
 func (self *memcached) Replace(key string, value interface{}, expiration time.Duration) (err error) {
+	if err = self.checkKey(key); err != nil {
+		return
+	}
 	buffer, flag, err := self.encode(value)
 	if err != nil {
 		return
@@ -214,13 +488,16 @@ func (self *memcached) Replace(key string, value interface{}, expiration time.Du
 	cs_value, value_len := cString(string(buffer))
 	defer C.free(unsafe.Pointer(cs_value))
 
-	return self.checkError(
+	return self.checkKeyError(cs_key, key_len,
 		C.memcached_replace(
 			self.mc, cs_key, key_len, cs_value, value_len,
 			C.time_t(expiration.Seconds()), C.uint32_t(flag)))
 }
 
 func (self *memcached) Set(key string, value interface{}, expiration time.Duration) (err error) {
+	if err = self.checkKey(key); err != nil {
+		return
+	}
 	buffer, flag, err := self.encode(value)
 	if err != nil {
 		return
@@ -230,12 +507,96 @@ func (self *memcached) Set(key string, value interface{}, expiration time.Durati
 	cs_value, value_len := cString(string(buffer))
 	defer C.free(unsafe.Pointer(cs_value))
 
-	return self.checkError(
+	return self.checkKeyError(cs_key, key_len,
 		C.memcached_set(
 			self.mc, cs_key, key_len, cs_value, value_len,
 			C.time_t(expiration.Seconds()), C.uint32_t(flag)))
 }
 
+// Append checks the flags of the stored value before appending to it on the
+// server, see checkAppendable. A value replaced by an encoded one between the
+// check and the append still gets corrupted.
+func (self *memcached) Append(key string, value interface{}, expiration time.Duration) (err error) {
+	if err = self.checkKey(key); err != nil {
+		return
+	}
+	buffer, flag, err := self.encodeAppendable(value)
+	if err != nil {
+		return
+	}
+	if err = checkAppendable(self.storedFlags(key)); err != nil {
+		return
+	}
+	cs_key, key_len := cString(key)
+	defer C.free(unsafe.Pointer(cs_key))
+	cs_value, value_len := cString(string(buffer))
+	defer C.free(unsafe.Pointer(cs_value))
+
+	return self.checkKeyError(cs_key, key_len,
+		C.memcached_append(
+			self.mc, cs_key, key_len, cs_value, value_len,
+			C.time_t(expiration.Seconds()), C.uint32_t(flag)))
+}
+
+// Prepend checks the flags of the stored value as Append does.
+func (self *memcached) Prepend(key string, value interface{}, expiration time.Duration) (err error) {
+	if err = self.checkKey(key); err != nil {
+		return
+	}
+	buffer, flag, err := self.encodeAppendable(value)
+	if err != nil {
+		return
+	}
+	if err = checkAppendable(self.storedFlags(key)); err != nil {
+		return
+	}
+	cs_key, key_len := cString(key)
+	defer C.free(unsafe.Pointer(cs_key))
+	cs_value, value_len := cString(string(buffer))
+	defer C.free(unsafe.Pointer(cs_value))
+
+	return self.checkKeyError(cs_key, key_len,
+		C.memcached_prepend(
+			self.mc, cs_key, key_len, cs_value, value_len,
+			C.time_t(expiration.Seconds()), C.uint32_t(flag)))
+}
+
+// storedFlags reads the flags of the value of key. libmemcached has no
+// flags-only read, so the value is read along.
+func (self *memcached) storedFlags(key string) (flags uint32, err error) {
+	res, err := self.getMulti([]string{key})
+	if err != nil {
+		return
+	}
+	item, err := res.Item(key)
+	if err != nil {
+		return
+	}
+	return item.Flags, nil
+}
+
+func (self *memcached) CompareAndSwap(key string, value interface{}, cas uint64, expiration time.Duration) (err error) {
+	if err = self.checkKey(key); err != nil {
+		return
+	}
+	buffer, flag, err := self.encode(value)
+	if err != nil {
+		return
+	}
+	cs_key, key_len := cString(key)
+	defer C.free(unsafe.Pointer(cs_key))
+	cs_value, value_len := cString(string(buffer))
+	defer C.free(unsafe.Pointer(cs_value))
+
+	return self.checkKeyError(cs_key, key_len,
+		C.memcached_cas(
+			self.mc, cs_key, key_len, cs_value, value_len,
+			C.time_t(expiration.Seconds()), C.uint32_t(flag), C.uint64_t(cas)))
+}
+
 func (self *memcached) Close() {
 	C.memcached_free(self.mc)
+	if self.pipeline != nil {
+		self.pipeline.Close()
+	}
 }
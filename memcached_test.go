@@ -1,21 +1,16 @@
 package gomc
 
 import (
-	"bufio"
-	"os/exec"
+	"context"
+	"errors"
+	"flag"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
-)
 
-const (
-	_MC_CMD               = "memcached"
-	_MC_FLAG_SOCKET       = "-s"
-	_MC_FLAG_TCP_PORT     = "-p"
-	_MC_FLAG_VERY_VERBOSE = "-vv"
-	_MC_START_LISTENING   = "server listening"
+	"github.com/ianoshen/gomc/gomctest"
 )
 
 var (
@@ -32,43 +27,33 @@ var (
 	}
 )
 
-func start(servers []string) (cmds []*exec.Cmd) {
-	cmds = make([]*exec.Cmd, 0, len(servers))
-	for _, server := range servers {
-		args := []string{_MC_FLAG_VERY_VERBOSE}
-		if strings.HasPrefix(server, "/") {
-			args = append(args, _MC_FLAG_SOCKET, server)
-		} else {
-			port := strings.Split(server, ":")[1]
-			args = append(args, _MC_FLAG_TCP_PORT, port)
-		}
-		cmd := exec.Command(_MC_CMD, args...)
-		stderr, _ := cmd.StderrPipe()
-		buf := bufio.NewReader(stderr)
-
-		cmd.Start()
-		for {
-			line, _ := buf.ReadString('\n')
-			if strings.Contains(line, _MC_START_LISTENING) {
-				break
-			}
-		}
-		cmds = append(cmds, cmd)
+var testNative = flag.Bool("native", false, "run the client tests against the native backend")
+
+func testBackend() BackendType {
+	if *testNative {
+		return BACKEND_NATIVE
 	}
-	return
+	return BACKEND_DEFAULT
 }
 
-func stop(cmds []*exec.Cmd) {
-	for _, cmd := range cmds {
-		cmd.Process.Kill()
+func newTestClient(servers []string, encoding EncodingType) (Client, error) {
+	configs := parseServers(servers)
+	return newBackend(testBackend(), clientConfig(configs), configs, false, 1, encoding)
+}
+
+func newTestPool(servers []string, initSize, maxSize int, encoding EncodingType) (Pool, error) {
+	configs := parseServers(servers)
+	client, err := newBackend(testBackend(), poolConfig(configs, initSize, maxSize), configs, true, maxSize, encoding)
+	if err != nil {
+		return nil, err
 	}
+	return client.(Pool), nil
 }
 
 func TestBehavior(t *testing.T) {
-	cmds := start(testHosts)
-	defer stop(cmds)
+	gomctest.StartAt(t, testHosts...)
 
-	mc, err := newMemcached(testHosts, ENCODING_DEFAULT)
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
 	if err != nil {
 		t.Error("Fail to new client:", err)
 	}
@@ -83,13 +68,12 @@ func TestBehavior(t *testing.T) {
 }
 
 func TestSetGet(t *testing.T) {
-	cmds := start(testHosts)
-	defer stop(cmds)
+	gomctest.StartAt(t, testHosts...)
 
 	testKey := "test-key"
 	testValue := "test-value"
 	testExpr := time.Second
-	mc, err := newMemcached(testHosts, ENCODING_DEFAULT)
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
 	if err != nil {
 		t.Error("Fail to new client:", err)
 	}
@@ -113,13 +97,12 @@ func TestSetGet(t *testing.T) {
 }
 
 func testSetGetWithEncoding(t *testing.T, encoding EncodingType) {
-	cmds := start(testHosts)
-	defer stop(cmds)
+	gomctest.StartAt(t, testHosts...)
 
 	testKey := "test-key"
 	testValue := randomStruct()
 	restoreValue := new(TestStruct)
-	mc, err := newMemcached(testHosts, encoding)
+	mc, err := newTestClient(testHosts, encoding)
 	if err != nil {
 		t.Error("Fail to new client:", err)
 	}
@@ -143,14 +126,13 @@ func TestJsonSetGet(t *testing.T) {
 }
 
 func TestGetMulti(t *testing.T) {
-	cmds := start(testHosts)
-	defer stop(cmds)
+	gomctest.StartAt(t, testHosts...)
 
 	num := 3
 	testKeyPrefix := "test-key:"
 	testKeys := make([]string, num)
 	testStructs := make(map[string]*TestStruct, num)
-	mc, err := newMemcached(testHosts, ENCODING_JSON)
+	mc, err := newTestClient(testHosts, ENCODING_JSON)
 	if err != nil {
 		t.Error("Fail to new client:", err)
 	}
@@ -181,12 +163,11 @@ func TestGetMulti(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
-	cmds := start(testHosts)
-	defer stop(cmds)
+	gomctest.StartAt(t, testHosts...)
 
 	testKey := "test-key"
 	testValue := "test-value"
-	mc, err := newMemcached(testHosts, ENCODING_DEFAULT)
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
 	if err != nil {
 		t.Error("Fail to new client:", err)
 	}
@@ -205,13 +186,625 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestIncrementWithInitial(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	testKey := "test-counter"
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	if err = mc.SetBehavior(BEHAVIOR_BINARY_PROTOCOL, 1); err != nil {
+		t.Error("Fail to set behavior:", err)
+	}
+
+	if value, err := mc.IncrementWithInitial(testKey, 1<<40, 10, 0); err != nil {
+		t.Error("Fail to increment:", err)
+	} else if value != 10 {
+		t.Error("Error increment:", value, ", expect:", 10)
+	}
+
+	if value, err := mc.IncrementWithInitial(testKey, 1<<40, 10, 0); err != nil {
+		t.Error("Fail to increment:", err)
+	} else if value != 10+1<<40 {
+		t.Error("Error increment:", value, ", expect:", 10+1<<40)
+	}
+
+	if value, err := mc.DecrementWithInitial(testKey, 1<<40, 10, 0); err != nil {
+		t.Error("Fail to decrement:", err)
+	} else if value != 10 {
+		t.Error("Error decrement:", value, ", expect:", 10)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	testKey := "test-key"
+	testValue := "test-value"
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	if err = mc.Set(testKey, testValue, 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+
+	var val string
+	cas, err := mc.GetWithCAS(testKey, &val)
+	if err != nil {
+		t.Error("Fail to get with cas:", err)
+	} else if val != testValue {
+		t.Error("Error get:", val, ", expect:", testValue)
+	}
+
+	res, err := mc.GetMulti([]string{testKey})
+	if err != nil {
+		t.Error("Fail to get-multi:", err)
+	} else if multiCas, _ := res.CAS(testKey); multiCas != cas {
+		t.Error("Error cas:", multiCas, ", expect:", cas)
+	}
+
+	if err = mc.CompareAndSwap(testKey, "new-value", cas, 0); err != nil {
+		t.Error("Fail to compare and swap:", err)
+	}
+
+	if err = mc.CompareAndSwap(testKey, "stale-value", cas, 0); !errors.Is(err, ErrCASConflict) {
+		t.Error("Error compare and swap:", err, ", expect:", ErrCASConflict)
+	}
+
+	if err = mc.Get(testKey, &val); err != nil {
+		t.Error("Fail to get:", err)
+	} else if val != "new-value" {
+		t.Error("Error get:", val, ", expect:", "new-value")
+	}
+}
+
+func TestAppendPrepend(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	testKey := "test-key"
+	mc, err := newTestClient(testHosts, ENCODING_JSON)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	if err = mc.Set(testKey, "b", 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+
+	if err = mc.Append(testKey, "c", 0); err != nil {
+		t.Error("Fail to append:", err)
+	}
+
+	if err = mc.Prepend(testKey, "a", 0); err != nil {
+		t.Error("Fail to prepend:", err)
+	}
+
+	var val string
+	if err = mc.Get(testKey, &val); err != nil {
+		t.Error("Fail to get:", err)
+	} else if val != "abc" {
+		t.Error("Error get:", val, ", expect:", "abc")
+	}
+
+	if err = mc.Append(testKey, randomStruct(), 0); err != ErrNotAppendable {
+		t.Error("Error append:", err, ", expect:", ErrNotAppendable)
+	}
+}
+
+func TestAppendEncoded(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	testKey := "test-key"
+	for _, encoding := range []EncodingType{ENCODING_GOB, ENCODING_JSON} {
+		mc, err := newTestClient(testHosts, encoding)
+		if err != nil {
+			t.Fatal("Fail to new client:", err)
+		}
+		value := randomStruct()
+		if err = mc.Set(testKey, value, 0); err != nil {
+			t.Error("Fail to set:", err)
+		}
+		if err = mc.Append(testKey, "tail", 0); err != ErrNotAppendable {
+			t.Error("Error append:", err, ", expect:", ErrNotAppendable)
+		}
+		if err = mc.Prepend(testKey, "head", 0); err != ErrNotAppendable {
+			t.Error("Error prepend:", err, ", expect:", ErrNotAppendable)
+		}
+		var got TestStruct
+		if err = mc.Get(testKey, &got); err != nil || got.format() != value.format() {
+			t.Error("Error get:", got.format(), err, ", expect:", value.format())
+		}
+		if err = mc.Append("test-key:missing", "tail", 0); !errors.Is(err, ErrNotStored) {
+			t.Error("Error append missing:", err, ", expect:", ErrNotStored)
+		}
+		mc.Close()
+	}
+}
+
+func TestTouch(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	testKeys := []string{"test-key:0", "test-key:1"}
+	testValue := "test-value"
+	testExpr := time.Second
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	for _, testKey := range testKeys {
+		if err = mc.Set(testKey, testValue, testExpr); err != nil {
+			t.Error("Fail to set:", err)
+		}
+	}
+
+	if err = mc.TouchMulti(testKeys, 3*testExpr); err != nil {
+		t.Error("Fail to touch multi:", err)
+	}
+
+	var val string
+	if err = mc.GetAndTouch(testKeys[0], &val, 3*testExpr); err != nil {
+		t.Error("Fail to get and touch:", err)
+	} else if val != testValue {
+		t.Error("Error get:", val, ", expect:", testValue)
+	}
+
+	time.Sleep(2 * testExpr)
+
+	for _, testKey := range testKeys {
+		if err = mc.Get(testKey, &val); err != nil {
+			t.Error("Fail to get touched key:", err)
+		}
+	}
+
+	if err = mc.Touch("missing-key", testExpr); !errors.Is(err, ErrNotFound) {
+		t.Error("Error touch:", err, ", expect:", ErrNotFound)
+	}
+
+	err = mc.TouchMulti(append(testKeys, "missing-key"), testExpr)
+	var errs MultiError
+	if !errors.As(err, &errs) || len(errs) != 1 || !errors.Is(errs["missing-key"], ErrNotFound) {
+		t.Error("Error touch multi:", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	stats, err := mc.Stats("")
+	if err != nil {
+		t.Error("Fail to stats:", err)
+	}
+	for _, host := range testHosts {
+		if s, ok := stats[host]; !ok {
+			t.Error("No stats for server:", host)
+		} else if s.Pid == 0 || s.Version == "" {
+			t.Error("Error stats:", s)
+		}
+	}
+
+	settings, err := mc.Stats("settings")
+	if err != nil {
+		t.Error("Fail to stats settings:", err)
+	}
+	for _, host := range testHosts {
+		if _, ok := settings[host].Raw["maxbytes"]; !ok {
+			t.Error("No maxbytes setting for server:", host)
+		}
+	}
+}
+
+func TestVersionsPing(t *testing.T) {
+	cluster := gomctest.StartAt(t, testHosts...)
+
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	versions, err := mc.Versions()
+	if err != nil {
+		t.Error("Fail to get versions:", err)
+	}
+	for _, host := range testHosts {
+		if versions[host] == "" {
+			t.Error("No version for server:", host)
+		}
+	}
+
+	cluster.Server(2).Kill()
+	res, err := mc.Ping()
+	if err == nil {
+		t.Error("Ping should fail with a stopped server")
+	}
+	for i, host := range testHosts {
+		if alive := res[host].Err == nil; alive != (i < 2) {
+			t.Error("Error ping:", host, res[host].Err)
+		}
+	}
+}
+
+func TestServers(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	mc, err := newTestClient(testHosts[:2], ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	if err = mc.AddServer("localhost", 11213, 2); err != nil {
+		t.Error("Fail to add server:", err)
+	}
+	if err = mc.AddServer("localhost", 11213, 2); !errors.Is(err, ErrServerExists) {
+		t.Error("Error add server:", err, ", expect:", ErrServerExists)
+	}
+
+	servers, err := mc.Servers()
+	if err != nil {
+		t.Error("Fail to list servers:", err)
+	} else if len(servers) != 3 {
+		t.Error("Error servers size:", len(servers), ", expect:", 3)
+	} else if last := servers[2]; last.Port != 11213 || last.Weight != 2 || last.Connection != CONNECTION_TCP {
+		t.Error("Error server:", last)
+	}
+
+	if err = mc.RemoveServer("localhost", 11211); err != nil {
+		t.Error("Fail to remove server:", err)
+	}
+	if err = mc.RemoveServer("localhost", 11211); !errors.Is(err, ErrUnknownServer) {
+		t.Error("Error remove server:", err, ", expect:", ErrUnknownServer)
+	}
+
+	servers, _ = mc.Servers()
+	for _, server := range servers {
+		if server.Port == 11211 {
+			t.Error("Server not removed:", server)
+		}
+	}
+
+	if err = mc.Set("test-key", "test-value", 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+}
+
+func TestServersForKeys(t *testing.T) {
+	num := 1000
+	testKeys := make([]string, num)
+	for i := range testKeys {
+		testKeys[i] = "test-key:" + strconv.Itoa(i)
+	}
+
+	mc, err := newTestClient(testHosts[:2], ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+	if err = mc.SetBehavior(BEHAVIOR_DISTRIBUTION, uint64(DISTRIBUTION_CONSISTENT_KETAMA)); err != nil {
+		t.Error("Fail to set behavior:", err)
+	}
+
+	before, err := mc.ServersForKeys(testKeys)
+	if err != nil {
+		t.Error("Fail to group keys:", err)
+	}
+	info, err := mc.ServerForKey(testKeys[0])
+	if err != nil {
+		t.Error("Fail to get server for key:", err)
+	} else if group := before[info.Name()]; len(group) == 0 || group[0] != testKeys[0] {
+		t.Error("Error server for key:", info.Name())
+	}
+
+	if err = mc.AddServer("localhost", 11213, 1); err != nil {
+		t.Error("Fail to add server:", err)
+	}
+	after, err := mc.ServersForKeys(testKeys)
+	if err != nil {
+		t.Error("Fail to group keys:", err)
+	}
+
+	// With consistent hashing only keys moving to the new server change node.
+	moved := len(after["localhost:11213"])
+	for server, keys := range before {
+		if len(keys)-len(after[server]) > moved {
+			t.Error("Keys moved between old servers:", server)
+		}
+	}
+	if moved == 0 || moved > num/2 {
+		t.Error("Error moved keys:", moved)
+	}
+}
+
+func TestContext(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	testKey := "test-key"
+	testValue := "test-value"
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = mc.SetContext(ctx, testKey, testValue, 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	var val string
+	if err = mc.GetContext(ctx, testKey, &val); err != nil {
+		t.Error("Fail to get:", err)
+	} else if val != testValue {
+		t.Error("Error get:", val, ", expect:", testValue)
+	}
+	if timeout, _ := mc.GetBehavior(BEHAVIOR_POLL_TIMEOUT); timeout < 1000 {
+		t.Error("Error poll timeout not restored:", timeout)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = mc.GetContext(canceled, testKey, &val); err != context.Canceled {
+		t.Error("Error get:", err, ", expect:", context.Canceled)
+	}
+
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if _, err = mc.StatsContext(expired, ""); err != context.DeadlineExceeded {
+		t.Error("Error stats:", err, ", expect:", context.DeadlineExceeded)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	mc, err := newTestClient(testHosts[:1], ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+	raw, err := newTestClient(testHosts[:1], ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+	if err = mc.SetNamespace("app:"); err != nil {
+		t.Error("Fail to set namespace:", err)
+	}
+	if mc.Namespace() != "app:" {
+		t.Error("Error namespace:", mc.Namespace())
+	}
+
+	if err = mc.Set("foo", "bar", 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	var value string
+	if err = raw.Get("app:foo", &value); err != nil || value != "bar" {
+		t.Error("Error prefixed value:", value, err)
+	}
+	if err = raw.Get("foo", &value); !errors.Is(err, ErrNotFound) {
+		t.Error("Error unprefixed key:", err)
+	}
+
+	res, err := mc.GetMulti([]string{"foo"})
+	if err != nil {
+		t.Error("Fail to get multi:", err)
+	}
+	if err = res.Get("foo", &value); err != nil || value != "bar" {
+		t.Error("Error result for unprefixed key:", value, err)
+	}
+
+	if err = mc.Delete("foo", 0); err != nil {
+		t.Error("Fail to delete:", err)
+	}
+	if err = raw.Get("app:foo", &value); !errors.Is(err, ErrNotFound) {
+		t.Error("Error deleted key:", err)
+	}
+
+	key := strings.Repeat("k", _MAX_KEY_SIZE-len("app:")+1)
+	if err = mc.Set(key, "bar", 0); !errors.Is(err, ErrKeyTooBig) {
+		t.Error("Error long key:", err)
+	}
+	if err = raw.Set(key, "bar", 0); err != nil {
+		t.Error("Fail to set without namespace:", err)
+	}
+
+	if err = mc.SetNamespace(""); err != nil {
+		t.Error("Fail to clear namespace:", err)
+	}
+	if err = mc.Get(key, &value); err != nil || value != "bar" {
+		t.Error("Error value without namespace:", value, err)
+	}
+}
+
+func TestNamespaceHash(t *testing.T) {
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+	plain, _ := mc.GenerateHash("foo")
+	mc.SetNamespace("app:")
+	if hash, _ := mc.GenerateHash("foo"); hash != plain {
+		t.Error("Error hash without prefix key hashing:", hash)
+	}
+
+	if err = mc.SetBehavior(BEHAVIOR_HASH_WITH_PREFIX_KEY, 1); err != nil {
+		t.Error("Fail to set behavior:", err)
+	}
+	prefixed, _ := mc.GenerateHash("foo")
+	mc.SetNamespace("")
+	if hash, _ := mc.GenerateHash("app:foo"); hash != prefixed {
+		t.Error("Error hash with prefix key hashing:", hash)
+	}
+}
+
+func TestNamespaceServerForKey(t *testing.T) {
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
+	if err != nil {
+		t.Fatal("Fail to new client:", err)
+	}
+	servers, err := mc.Servers()
+	if err != nil {
+		t.Fatal("Fail to get servers:", err)
+	}
+	mc.SetNamespace("app:")
+
+	// The hash of a key points to the server it is stored on, whether the
+	// namespace is hashed or not.
+	for _, prefix := range []uint64{0, 1} {
+		if err = mc.SetBehavior(BEHAVIOR_HASH_WITH_PREFIX_KEY, prefix); err != nil {
+			t.Error("Fail to set behavior:", err)
+		}
+		for i := 0; i < 20; i++ {
+			key := "test-key:" + strconv.Itoa(i)
+			hash, _ := mc.GenerateHash(key)
+			server, err := mc.ServerForKey(key)
+			if err != nil || int(hash) >= len(servers) || servers[hash].Name() != server.Name() {
+				t.Error("Error server for key:", key, hash, server, err, ", prefix key hashing:", prefix)
+			}
+		}
+	}
+}
+
+func TestMulti(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	items := map[string]interface{}{"foo": "1", "bar": "2", "baz": "3"}
+	if err = mc.SetMulti(items, 0); err != nil {
+		t.Error("Fail to set multi:", err)
+	}
+	res, err := mc.GetMulti([]string{"foo", "bar", "baz"})
+	if err != nil {
+		t.Error("Fail to get multi:", err)
+	}
+	for key, expected := range items {
+		var value string
+		if err = res.Get(key, &value); err != nil || value != expected {
+			t.Error("Error value:", key, value, err)
+		}
+	}
+
+	err = mc.AddMulti(map[string]interface{}{"foo": "4", "qux": "5"}, 0)
+	var errs MultiError
+	if !errors.As(err, &errs) || len(errs) != 1 || !errors.Is(errs["foo"], ErrNotStored) {
+		t.Error("Error add multi:", err)
+	}
+
+	if err = mc.DeleteMulti([]string{"foo", "bar", "qux"}); err != nil {
+		t.Error("Fail to delete multi:", err)
+	}
+	if err = mc.DeleteMulti([]string{"foo", "baz"}); !errors.As(err, &errs) || len(errs) != 1 || !errors.Is(errs["foo"], ErrNotFound) {
+		t.Error("Error delete multi:", err)
+	}
+
+	// The replies of a batch larger than a window are read as it is sent.
+	large := make(map[string]interface{}, 1000)
+	for i := 0; i < 1000; i++ {
+		large["test-key:"+strconv.Itoa(i)] = i
+	}
+	if err = mc.SetMulti(large, 0); err != nil {
+		t.Error("Fail to set large multi:", err)
+	}
+	if err = mc.AddMulti(large, 0); !errors.As(err, &errs) || len(errs) != len(large) {
+		t.Error("Error add large multi:", len(errs), err)
+	}
+}
+
+func TestGetMultiFunc(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	num := 2500
+	keys := make([]string, num+1)
+	items := make(map[string]interface{}, num)
+	for i := 0; i < num; i++ {
+		keys[i] = "test-key:" + strconv.Itoa(i)
+		items[keys[i]] = i
+	}
+	keys[num] = "missing"
+	if err = mc.SetMulti(items, 0); err != nil {
+		t.Error("Fail to set multi:", err)
+	}
+
+	seen := make(map[string]bool, num)
+	err = mc.GetMultiFunc(keys, func(item *Item) error {
+		var value int
+		if err := item.Decode(&value); err != nil || items[item.Key] != value {
+			t.Error("Error item:", item.Key, value, err)
+		}
+		seen[item.Key] = true
+		return nil
+	})
+	if err != nil || len(seen) != num {
+		t.Error("Error streamed items:", len(seen), err)
+	}
+
+	// A failing callback stops the iteration but leaves the client usable.
+	errStop := errors.New("stop")
+	count := 0
+	err = mc.GetMultiFunc(keys, func(item *Item) error {
+		count++
+		return errStop
+	})
+	if err != errStop || count != 1 {
+		t.Error("Error stopped iteration:", count, err)
+	}
+	var value int
+	if err = mc.Get(keys[0], &value); err != nil || value != 0 {
+		t.Error("Error value after stopped iteration:", value, err)
+	}
+}
+
+func TestGetMultiSomeErrors(t *testing.T) {
+	gomctest.StartAt(t, testHosts[:1]...)
+
+	mc, err := newTestClient(testHosts[:2], ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = "test-key:" + strconv.Itoa(i)
+		mc.Set(keys[i], i, 0)
+	}
+
+	res, err := mc.GetMulti(keys)
+	if !errors.Is(err, ErrSomeErrors) {
+		t.Error("Error partial multi get:", err)
+	}
+	if res.Len() == 0 || res.Len()+len(res.Missing()) != len(keys) {
+		t.Error("Error partial result:", res.Len(), len(res.Missing()))
+	}
+	if _, ok := res.Errors()["localhost:11212"]; !ok || len(res.Errors()) != 1 {
+		t.Error("Error server errors:", res.Errors())
+	}
+	for _, key := range res.Missing() {
+		var value int
+		if err = res.Get(key, &value); errors.Is(err, ErrNotFound) {
+			t.Error("Error key of failed server reported as a miss:", key)
+		}
+	}
+}
+
 func BenchmarkSet(b *testing.B) {
 	b.StopTimer()
 
-	cmds := start(testHosts)
-	defer stop(cmds)
+	gomctest.StartAt(b, testHosts...)
 
-	mc, _ := newMemcached(testHosts, ENCODING_DEFAULT)
+	mc, _ := newTestClient(testHosts, ENCODING_DEFAULT)
 	testKey := "test-key"
 	testValue := "test-value"
 
@@ -225,10 +818,9 @@ func BenchmarkSet(b *testing.B) {
 func BenchmarkGet(b *testing.B) {
 	b.StopTimer()
 
-	cmds := start(testHosts)
-	defer stop(cmds)
+	gomctest.StartAt(b, testHosts...)
 
-	mc, _ := newMemcached(testHosts, ENCODING_DEFAULT)
+	mc, _ := newTestClient(testHosts, ENCODING_DEFAULT)
 	testKey := "test-key"
 	testValue := "test-value"
 	restoreValue := new(string)
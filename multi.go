@@ -0,0 +1,62 @@
+package gomc
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	_GET_MULTI_BATCH_SIZE = 1000
+)
+
+// MultiError maps each key a batch operation failed on to its error.
+type MultiError map[string]error
+
+func (self MultiError) keys() []string {
+	keys := make([]string, 0, len(self))
+	for key := range self {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (self MultiError) Error() string {
+	keys := self.keys()
+	return fmt.Sprintf("%d key(s) failed, first `%s`: %v", len(keys), keys[0], self[keys[0]])
+}
+
+// Unwrap lets errors.Is and errors.As look into every key's error.
+func (self MultiError) Unwrap() []error {
+	errs := make([]error, 0, len(self))
+	for _, key := range self.keys() {
+		errs = append(errs, self[key])
+	}
+	return errs
+}
+
+func (self MultiError) err() error {
+	if len(self) == 0 {
+		return nil
+	}
+	return self
+}
+
+func itemKeys(items map[string]interface{}) []string {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// eachKey runs op for every key and gathers the failures.
+func eachKey(keys []string, op func(string) error) error {
+	errs := make(MultiError)
+	for _, key := range keys {
+		if err := op(key); err != nil {
+			errs[key] = err
+		}
+	}
+	return errs.err()
+}
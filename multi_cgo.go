@@ -0,0 +1,153 @@
+//go:build cgo
+
+package gomc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The behaviors a pipeline copies from libmemcached, so that it sends every
+// key to the same server with the same protocol, timeouts and failure
+// handling. Those the native backend refuses leave the batches to
+// libmemcached.
+var pipelineBehaviors = []BehaviorType{
+	BEHAVIOR_USE_UDP,
+	BEHAVIOR_NUMBER_OF_REPLICAS,
+	BEHAVIOR_KETAMA_WEIGHTED,
+	BEHAVIOR_DISTRIBUTION,
+	BEHAVIOR_HASH,
+	BEHAVIOR_HASH_WITH_PREFIX_KEY,
+	BEHAVIOR_BINARY_PROTOCOL,
+	BEHAVIOR_TCP_NODELAY,
+	BEHAVIOR_CONNECT_TIMEOUT,
+	BEHAVIOR_POLL_TIMEOUT,
+	BEHAVIOR_SND_TIMEOUT,
+	BEHAVIOR_RCV_TIMEOUT,
+	BEHAVIOR_RETRY_TIMEOUT,
+	BEHAVIOR_SERVER_FAILURE_LIMIT,
+	BEHAVIOR_AUTO_EJECT_HOSTS,
+	BEHAVIOR_DEAD_TIMEOUT,
+}
+
+// pipeline sends the batches of a libmemcached client over native
+// connections of its own, libmemcached having no way to send requests at once
+// and still read a reply per key. Its client copies the servers, namespace
+// and pipelineBehaviors of libmemcached and is rebuilt when they change. The
+// servers libmemcached marked as failed are tracked apart.
+type pipeline struct {
+	maxSize int
+
+	lock     sync.Mutex
+	client   Client
+	settings string
+	err      error
+}
+
+func newPipeline(maxSize int) *pipeline {
+	return &pipeline{maxSize: maxSize}
+}
+
+// get returns the client for the settings of mc, or the error of the native
+// backend refusing them.
+func (self *pipeline) get(mc *memcached) (Client, error) {
+	values := make([]uint64, len(pipelineBehaviors))
+	for i, behavior := range pipelineBehaviors {
+		values[i], _ = mc.GetBehavior(behavior)
+	}
+	settings := fmt.Sprint(mc.servers, mc.namespace, values)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if settings == self.settings {
+		return self.client, self.err
+	}
+	if self.client != nil {
+		self.client.Close()
+	}
+	self.client, self.err = newPipelineClient(mc, self.maxSize, values)
+	self.settings = settings
+	return self.client, self.err
+}
+
+func newPipelineClient(mc *memcached, maxSize int, values []uint64) (client Client, err error) {
+	if client, err = newNative(mc.servers, maxSize, mc.encoding); err != nil {
+		return
+	}
+	for i, behavior := range pipelineBehaviors {
+		if err = client.SetBehavior(behavior, values[i]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = client.SetNamespace(mc.namespace)
+	}
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return
+}
+
+func (self *pipeline) Close() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.client != nil {
+		self.client.Close()
+	}
+	self.client, self.settings, self.err = nil, "", nil
+}
+
+// batch sends a batch through the pipeline, a round trip per server with a
+// reply per key, with pipelined. Settings the pipeline can not copy leave it
+// to op, a round trip per key, within the poll timeout capped by ctx.
+func (self *memcached) batch(ctx context.Context, keys []string, pipelined func(Client) error, op func(string) error) error {
+	if self.pipeline != nil {
+		if client, err := self.pipeline.get(self); err == nil {
+			return pipelined(client)
+		}
+	}
+	return self.withContext(ctx, func() error {
+		return eachKey(keys, op)
+	})
+}
+
+func (self *memcached) setMulti(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	return self.batch(ctx, itemKeys(items), func(client Client) error {
+		return client.SetMultiContext(ctx, items, expiration)
+	}, func(key string) error {
+		return self.Set(key, items[key], expiration)
+	})
+}
+
+func (self *memcached) addMulti(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	return self.batch(ctx, itemKeys(items), func(client Client) error {
+		return client.AddMultiContext(ctx, items, expiration)
+	}, func(key string) error {
+		return self.Add(key, items[key], expiration)
+	})
+}
+
+func (self *memcached) deleteMulti(ctx context.Context, keys []string) error {
+	return self.batch(ctx, keys, func(client Client) error {
+		return client.DeleteMultiContext(ctx, keys)
+	}, func(key string) error {
+		return self.Delete(key, 0)
+	})
+}
+
+func (self *memcached) SetMulti(items map[string]interface{}, expiration time.Duration) error {
+	return self.setMulti(context.Background(), items, expiration)
+}
+
+func (self *memcached) AddMulti(items map[string]interface{}, expiration time.Duration) error {
+	return self.addMulti(context.Background(), items, expiration)
+}
+
+func (self *memcached) DeleteMulti(keys []string) error {
+	return self.deleteMulti(context.Background(), keys)
+}
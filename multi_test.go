@@ -0,0 +1,30 @@
+package gomc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMultiError(t *testing.T) {
+	if err := make(MultiError).err(); err != nil {
+		t.Error("Error empty multi error:", err)
+	}
+
+	err := MultiError{
+		"b": ErrNotStored,
+		"a": ErrKeyTooBig,
+	}.err()
+	if err == nil {
+		t.Fatal("Error nil multi error")
+	}
+	if !errors.Is(err, ErrNotStored) || !errors.Is(err, ErrKeyTooBig) || errors.Is(err, ErrNotFound) {
+		t.Error("Error wrapped errors:", err)
+	}
+	var errs MultiError
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Error("Error multi error:", errs)
+	}
+	if err.Error() != "2 key(s) failed, first `a`: "+ErrKeyTooBig.Error() {
+		t.Error("Error message:", err.Error())
+	}
+}
@@ -0,0 +1,63 @@
+package gomc
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// memcached rejects keys longer than 250 bytes, namespace included.
+	_MAX_KEY_SIZE = 250
+)
+
+// checkNamespacedKey fails before anything is sent when the namespaced key
+// would be refused by the server.
+func checkNamespacedKey(namespace, key string) error {
+	if len(namespace)+len(key) > _MAX_KEY_SIZE {
+		return &Error{
+			Code:    KEY_TOO_BIG,
+			Message: "Key `" + namespace + key + "` exceeds " + strconv.Itoa(_MAX_KEY_SIZE) + " bytes",
+		}
+	}
+	return nil
+}
+
+// checkNamespace applies the checks libmemcached makes on a namespace to the
+// clients that do without it.
+func checkNamespace(namespace string) error {
+	if len(namespace) > _MAX_PREFIX_SIZE {
+		return &Error{Code: KEY_TOO_BIG, Message: "Namespace `" + namespace + "` is too long"}
+	}
+	if strings.IndexFunc(namespace, unicode.IsSpace) >= 0 {
+		return &Error{Code: BAD_KEY_PROVIDED, Message: "Namespace `" + namespace + "` contains whitespace"}
+	}
+	return nil
+}
+
+// resultKeys maps the keys read back to the requested ones. libmemcached
+// strips the namespace itself, but a key still carrying it is trimmed unless
+// it was requested as is.
+type resultKeys struct {
+	namespace string
+	requested map[string]bool
+}
+
+func newResultKeys(namespace string, keys []string) (res resultKeys) {
+	res.namespace = namespace
+	if res.namespace == "" {
+		return
+	}
+	res.requested = make(map[string]bool, len(keys))
+	for _, key := range keys {
+		res.requested[key] = true
+	}
+	return
+}
+
+func (self resultKeys) key(key string) string {
+	if self.namespace == "" || self.requested[key] {
+		return key
+	}
+	return strings.TrimPrefix(key, self.namespace)
+}
@@ -0,0 +1,67 @@
+//go:build cgo
+
+package gomc
+
+/*
+#include <libmemcached/memcached.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+func (self *memcached) readNamespace() string {
+	rc := new(C.memcached_return_t)
+	namespace := C.memcached_callback_get(self.mc, C.MEMCACHED_CALLBACK_PREFIX_KEY, rc)
+	if namespace == nil {
+		return ""
+	}
+	return C.GoString((*C.char)(namespace))
+}
+
+// SetNamespace prefixes every key sent by the client with namespace, an empty
+// one removing the prefix. Keys read back are reported without it.
+func (self *memcached) SetNamespace(namespace string) (err error) {
+	var cs_namespace *C.char
+	if namespace != "" {
+		cs_namespace = C.CString(namespace)
+		defer C.free(unsafe.Pointer(cs_namespace))
+	}
+	if err = self.checkError(
+		C.memcached_callback_set(
+			self.mc, C.MEMCACHED_CALLBACK_PREFIX_KEY, unsafe.Pointer(cs_namespace))); err != nil {
+		return
+	}
+	self.namespace = namespace
+	return
+}
+
+func (self *memcached) Namespace() string {
+	return self.namespace
+}
+
+func (self *memcached) applyNamespace(servers []serverConfig, namespace string) (err error) {
+	if err = self.applyServers(servers); err != nil {
+		return
+	}
+	return self.SetNamespace(namespace)
+}
+
+func (self *memcached) checkKey(key string) error {
+	return checkNamespacedKey(self.namespace, key)
+}
+
+func (self *memcached) checkKeys(keys []string) (err error) {
+	for _, key := range keys {
+		if err = self.checkKey(key); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (self *memcached) resultKeys(keys []string) resultKeys {
+	return newResultKeys(self.namespace, keys)
+}
@@ -0,0 +1,744 @@
+package gomc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of libmemcached 1.0.18, in milliseconds and seconds.
+const (
+	_NATIVE_POLL_TIMEOUT         = 1000
+	_NATIVE_CONNECT_TIMEOUT      = 4000
+	_NATIVE_RETRY_TIMEOUT        = 2
+	_NATIVE_SERVER_FAILURE_LIMIT = 5
+)
+
+// Behaviors the native backend can not honor, which it refuses to enable
+// rather than silently ignore. Every request waits for its reply, so
+// BEHAVIOR_BUFFER_REQUESTS and BEHAVIOR_NOREPLY are refused as well.
+var nativeUnsupported = map[BehaviorType]bool{
+	BEHAVIOR_KETAMA:             true,
+	BEHAVIOR_KETAMA_WEIGHTED:    true,
+	BEHAVIOR_KETAMA_HASH:        true,
+	BEHAVIOR_USE_UDP:            true,
+	BEHAVIOR_NUMBER_OF_REPLICAS: true,
+	BEHAVIOR_CORK:               true,
+	BEHAVIOR_BUFFER_REQUESTS:    true,
+	BEHAVIOR_NOREPLY:            true,
+}
+
+func notSupported(message string) error {
+	return &Error{Code: NOT_SUPPORTED, Message: message}
+}
+
+type nativeServer struct {
+	config serverConfig
+
+	lock      sync.Mutex
+	idle      []*nativeConn
+	lastError string
+	failures  uint64
+	retryAt   time.Time
+	ejected   bool
+}
+
+func (self *nativeServer) name() string {
+	return self.config.name()
+}
+
+// take returns an idle connection speaking the current protocol, those left
+// from before BEHAVIOR_BINARY_PROTOCOL changed being closed.
+func (self *nativeServer) take(binary bool) *nativeConn {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for len(self.idle) > 0 {
+		conn := self.idle[len(self.idle)-1]
+		self.idle = self.idle[:len(self.idle)-1]
+		if conn.binary == binary {
+			return conn
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+func (self *nativeServer) put(conn *nativeConn, maxIdle int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if len(self.idle) >= maxIdle {
+		conn.Close()
+		return
+	}
+	self.idle = append(self.idle, conn)
+}
+
+func (self *nativeServer) closeIdle() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for _, conn := range self.idle {
+		conn.Close()
+	}
+	self.idle = nil
+}
+
+func (self *nativeServer) setError(err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.lastError = ""
+	if err != nil {
+		self.lastError = err.Error()
+	}
+}
+
+// available refuses a server in timeout after a failure, until it is due for
+// a retry.
+func (self *nativeServer) available(now time.Time) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.retryAt.IsZero() || !now.Before(self.retryAt) {
+		return nil
+	}
+	if self.ejected {
+		return &Error{Code: SERVER_MARKED_DEAD, Message: self.lastError}
+	}
+	return &Error{Code: SERVER_TEMPORARILY_DISABLED, Message: self.lastError}
+}
+
+// fail records a failure of the server as libmemcached does. The server is
+// put in timeout for BEHAVIOR_RETRY_TIMEOUT seconds and, once it failed
+// BEHAVIOR_SERVER_FAILURE_LIMIT times in a row with BEHAVIOR_AUTO_EJECT_HOSTS,
+// ejected until then, or for BEHAVIOR_DEAD_TIMEOUT seconds when set. It
+// reports whether the server is ejected.
+func (self *nativeServer) fail(state *nativeState, err error, now time.Time) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.lastError = err.Error()
+	self.failures++
+	retry := time.Duration(state.behaviors[BEHAVIOR_RETRY_TIMEOUT]) * time.Second
+	limit := state.behaviors[BEHAVIOR_SERVER_FAILURE_LIMIT]
+	if limit > 0 && self.failures >= limit && state.behaviors[BEHAVIOR_AUTO_EJECT_HOSTS] != 0 {
+		self.ejected = true
+		if dead := state.behaviors[BEHAVIOR_DEAD_TIMEOUT]; dead > 0 {
+			retry = time.Duration(dead) * time.Second
+			// A dead server is retried once before being ejected again.
+			self.failures = limit - 1
+		}
+	}
+	self.retryAt = time.Time{}
+	if retry > 0 {
+		self.retryAt = now.Add(retry)
+	}
+	return self.ejected && retry > 0
+}
+
+func (self *nativeServer) succeed() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.lastError = ""
+	self.failures = 0
+	self.retryAt = time.Time{}
+	self.ejected = false
+}
+
+// ejectedUntil returns when an ejected server is due for a retry, the zero
+// time for the others.
+func (self *nativeServer) ejectedUntil() time.Time {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if !self.ejected {
+		return time.Time{}
+	}
+	return self.retryAt
+}
+
+func (self *nativeServer) info() ServerInfo {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	info := ServerInfo{
+		Host:       self.config.host,
+		Port:       self.config.port,
+		Weight:     self.config.weight,
+		Connection: self.config.connection,
+		State:      SERVER_STATE_ALIVE,
+		LastError:  self.lastError,
+	}
+	if self.lastError != "" {
+		info.State = SERVER_STATE_FAILED
+	}
+	return info
+}
+
+// nativeState is the configuration an operation runs with. It is never
+// modified once published, changes building a new one.
+type nativeState struct {
+	servers   []*nativeServer
+	continuum []continuumPoint
+	behaviors map[BehaviorType]uint64
+	namespace string
+	rebuildAt time.Time
+}
+
+func (self *nativeState) clone() *nativeState {
+	state := &nativeState{
+		servers:   self.servers,
+		behaviors: make(map[BehaviorType]uint64, len(self.behaviors)),
+		namespace: self.namespace,
+	}
+	for behavior, value := range self.behaviors {
+		state.behaviors[behavior] = value
+	}
+	return state
+}
+
+func (self *nativeState) hash() func([]byte) uint32 {
+	return nativeHashes[HashType(self.behaviors[BEHAVIOR_HASH])]
+}
+
+func (self *nativeState) distribution() DistributionType {
+	return DistributionType(self.behaviors[BEHAVIOR_DISTRIBUTION])
+}
+
+func (self *nativeState) binary() bool {
+	return self.behaviors[BEHAVIOR_BINARY_PROTOCOL] != 0
+}
+
+func (self *nativeState) configs() []serverConfig {
+	configs := make([]serverConfig, len(self.servers))
+	for i, server := range self.servers {
+		configs[i] = server.config
+	}
+	return configs
+}
+
+// rebuild builds the continuum of a consistent distribution, leaving out the
+// ejected servers as libmemcached does until the first of them is due for a
+// retry at rebuildAt. Keys are never moved by a modula distribution.
+func (self *nativeState) rebuild() {
+	self.continuum, self.rebuildAt = nil, time.Time{}
+	if self.distribution() == DISTRIBUTION_MODULA {
+		return
+	}
+
+	now := time.Now()
+	var configs []serverConfig
+	var indexes []int
+	for i, server := range self.servers {
+		if retryAt := server.ejectedUntil(); retryAt.After(now) {
+			if self.rebuildAt.IsZero() || retryAt.Before(self.rebuildAt) {
+				self.rebuildAt = retryAt
+			}
+			continue
+		}
+		configs = append(configs, server.config)
+		indexes = append(indexes, i)
+	}
+	if len(configs) == 0 {
+		self.continuum = newContinuum(self.configs(), self.hash())
+		return
+	}
+	self.continuum = newContinuum(configs, self.hash())
+	for i := range self.continuum {
+		self.continuum[i].index = indexes[self.continuum[i].index]
+	}
+}
+
+func (self *nativeState) findServer(name string) int {
+	return findServer(self.configs(), name)
+}
+
+// index picks the server of key as libmemcached does, the namespace only
+// being hashed with BEHAVIOR_HASH_WITH_PREFIX_KEY.
+func (self *nativeState) index(key string) int {
+	if len(self.servers) <= 1 {
+		return 0
+	}
+	if self.namespace != "" && self.behaviors[BEHAVIOR_HASH_WITH_PREFIX_KEY] != 0 {
+		key = self.namespace + key
+	}
+	value := self.hash()([]byte(key))
+	if self.continuum != nil {
+		return searchContinuum(self.continuum, value)
+	}
+	return int(value % uint32(len(self.servers)))
+}
+
+func (self *nativeState) server(key string) (*nativeServer, error) {
+	if len(self.servers) == 0 {
+		return nil, &Error{Code: NO_SERVERS}
+	}
+	return self.servers[self.index(key)], nil
+}
+
+func (self *nativeState) checkKey(key string) error {
+	if key == "" {
+		return &Error{Code: BAD_KEY_PROVIDED, Message: "Empty key"}
+	}
+	if err := checkNamespacedKey(self.namespace, key); err != nil {
+		return err
+	}
+	if !self.binary() {
+		return checkTextKey(self.namespace + key)
+	}
+	return nil
+}
+
+// groupKeys splits keys by server, servers being listed in the order their
+// first key was met.
+func (self *nativeState) groupKeys(keys []string) (servers []*nativeServer, groups map[*nativeServer][]string, err error) {
+	groups = make(map[*nativeServer][]string)
+	for _, key := range keys {
+		if err = self.checkKey(key); err != nil {
+			return
+		}
+		server, err := self.server(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := groups[server]; !ok {
+			servers = append(servers, server)
+		}
+		groups[server] = append(groups[server], key)
+	}
+	return
+}
+
+// The I/O timeouts, with the unit of each.
+type timeoutBehavior struct {
+	behavior BehaviorType
+	unit     time.Duration
+}
+
+var ioTimeouts = []timeoutBehavior{
+	{BEHAVIOR_POLL_TIMEOUT, time.Millisecond},
+	{BEHAVIOR_SND_TIMEOUT, time.Microsecond},
+	{BEHAVIOR_RCV_TIMEOUT, time.Microsecond},
+}
+
+// timeout is the I/O timeout of a request, the shortest of the poll, send
+// and receive timeouts.
+func (self *nativeState) timeout() (timeout time.Duration) {
+	for _, t := range ioTimeouts {
+		value := time.Duration(self.behaviors[t.behavior]) * t.unit
+		if value > 0 && (timeout == 0 || value < timeout) {
+			timeout = value
+		}
+	}
+	return
+}
+
+// deadline is the earliest of the I/O timeout and the deadline of ctx.
+func (self *nativeState) deadline(ctx context.Context) (deadline time.Time) {
+	if timeout := self.timeout(); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if limit, ok := ctx.Deadline(); ok && (deadline.IsZero() || limit.Before(deadline)) {
+		deadline = limit
+	}
+	return
+}
+
+func (self *nativeState) dialer() *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(self.behaviors[BEHAVIOR_CONNECT_TIMEOUT]) * time.Millisecond,
+		KeepAlive: -1,
+	}
+	if self.behaviors[BEHAVIOR_TCP_KEEPALIVE] != 0 {
+		dialer.KeepAlive = time.Duration(self.behaviors[BEHAVIOR_TCP_KEEPIDLE]) * time.Second
+	}
+	return dialer
+}
+
+func newNativeState(servers []serverConfig) (*nativeState, error) {
+	state := &nativeState{
+		behaviors: map[BehaviorType]uint64{
+			BEHAVIOR_POLL_TIMEOUT:         _NATIVE_POLL_TIMEOUT,
+			BEHAVIOR_CONNECT_TIMEOUT:      _NATIVE_CONNECT_TIMEOUT,
+			BEHAVIOR_RETRY_TIMEOUT:        _NATIVE_RETRY_TIMEOUT,
+			BEHAVIOR_SERVER_FAILURE_LIMIT: _NATIVE_SERVER_FAILURE_LIMIT,
+			BEHAVIOR_SUPPORT_CAS:          1,
+		},
+	}
+	for _, server := range servers {
+		if server.connection == CONNECTION_UDP {
+			return nil, notSupported("UDP servers are not supported by the native backend")
+		}
+		state.servers = append(state.servers, &nativeServer{config: server})
+	}
+	state.rebuild()
+	return state, nil
+}
+
+// checkBehavior refuses the behaviors and values the native backend can not
+// honor.
+func checkBehavior(behavior BehaviorType, value uint64) error {
+	if behavior < 0 || behavior >= BEHAVIOR_MAX {
+		return &Error{Code: INVALID_ARGUMENTS, Message: "Unknown behavior"}
+	}
+	if nativeUnsupported[behavior] && value != 0 {
+		return notSupported("Behavior not supported by the native backend")
+	}
+	switch behavior {
+	case BEHAVIOR_HASH:
+		if _, ok := nativeHashes[HashType(value)]; !ok {
+			return notSupported("Hash not supported by the native backend")
+		}
+	case BEHAVIOR_DISTRIBUTION:
+		switch DistributionType(value) {
+		case DISTRIBUTION_MODULA, DISTRIBUTION_CONSISTENT, DISTRIBUTION_CONSISTENT_KETAMA:
+		default:
+			return notSupported("Distribution not supported by the native backend")
+		}
+	}
+	return nil
+}
+
+// nativeClient talks to the servers over net.Conn, without libmemcached. It
+// is safe for concurrent use, at most maxSize operations running at once.
+type nativeClient struct {
+	encoding EncodingType
+	maxSize  int
+	slots    chan struct{}
+
+	checkoutTimeout int64
+	inUse           int64
+	waits           uint64
+	timeouts        uint64
+
+	lock   sync.Mutex
+	state  atomic.Pointer[nativeState]
+	closed atomic.Bool
+}
+
+func newNative(servers []serverConfig, maxSize int, encoding EncodingType) (Client, error) {
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	state, err := newNativeState(servers)
+	if err != nil {
+		return nil, err
+	}
+
+	self := &nativeClient{
+		encoding: encoding,
+		maxSize:  maxSize,
+		slots:    make(chan struct{}, maxSize),
+	}
+	self.state.Store(state)
+	return self, nil
+}
+
+func (self *nativeClient) update(fn func(*nativeState) error) (err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	state := self.state.Load().clone()
+	if err = fn(state); err != nil {
+		return
+	}
+	state.rebuild()
+	self.state.Store(state)
+	return
+}
+
+// load returns the current state, rebuilt once an ejected server is due for
+// a retry.
+func (self *nativeClient) load() *nativeState {
+	state := self.state.Load()
+	if !state.rebuildAt.IsZero() && !time.Now().Before(state.rebuildAt) {
+		self.update(func(*nativeState) error { return nil })
+		state = self.state.Load()
+	}
+	return state
+}
+
+func (self *nativeClient) SetBehavior(behavior BehaviorType, value uint64) error {
+	if err := checkBehavior(behavior, value); err != nil {
+		return err
+	}
+	return self.update(func(state *nativeState) error {
+		state.behaviors[behavior] = value
+		return nil
+	})
+}
+
+func (self *nativeClient) GetBehavior(behavior BehaviorType) (uint64, error) {
+	return self.state.Load().behaviors[behavior], nil
+}
+
+// SetCheckoutTimeout bounds the wait for a free slot once maxSize operations
+// are running, see Pool.SetCheckoutTimeout.
+func (self *nativeClient) SetCheckoutTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&self.checkoutTimeout, int64(timeout))
+}
+
+func (self *nativeClient) PoolStats() PoolStats {
+	return PoolStats{
+		MaxSize:  self.maxSize,
+		InUse:    atomic.LoadInt64(&self.inUse),
+		Waits:    atomic.LoadUint64(&self.waits),
+		Timeouts: atomic.LoadUint64(&self.timeouts),
+	}
+}
+
+type noWaitKey struct{}
+
+// NoWait returns a context under which an operation on an exhausted pool
+// fails at once with ErrPoolExhausted, whatever the checkout timeout.
+func NoWait(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noWaitKey{}, true)
+}
+
+func noWait(ctx context.Context) bool {
+	value, _ := ctx.Value(noWaitKey{}).(bool)
+	return value
+}
+
+// checkout waits for a free slot, then returns the function freeing it.
+func (self *nativeClient) checkout(ctx context.Context) (release func(), err error) {
+	if self.closed.Load() {
+		return nil, closedError()
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	select {
+	case self.slots <- struct{}{}:
+	default:
+		atomic.AddUint64(&self.waits, 1)
+		if noWait(ctx) {
+			atomic.AddUint64(&self.timeouts, 1)
+			return nil, ErrPoolExhausted
+		}
+		var expired <-chan time.Time
+		if timeout := time.Duration(atomic.LoadInt64(&self.checkoutTimeout)); timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		select {
+		case self.slots <- struct{}{}:
+		case <-expired:
+			atomic.AddUint64(&self.timeouts, 1)
+			return nil, ErrPoolExhausted
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	atomic.AddInt64(&self.inUse, 1)
+	return func() {
+		atomic.AddInt64(&self.inUse, -1)
+		<-self.slots
+	}, nil
+}
+
+func (self *nativeClient) dial(ctx context.Context, state *nativeState, server *nativeServer) (*nativeConn, error) {
+	return dialNative(ctx, state.dialer(), server.config, state.binary(), state.behaviors[BEHAVIOR_TCP_NODELAY] != 0)
+}
+
+func serverError(err error, server *nativeServer) error {
+	if e, ok := err.(*Error); ok && e.Server == "" {
+		copied := *e
+		copied.Server = server.name()
+		return &copied
+	}
+	return err
+}
+
+// exec runs fn on a connection to server, bounded by the I/O timeout and
+// by ctx. A connection left in an unknown state is closed, the others going
+// back to the idle ones of server.
+func (self *nativeClient) exec(ctx context.Context, state *nativeState, server *nativeServer, fn func(*nativeConn) error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if err = server.available(time.Now()); err != nil {
+		return serverError(err, server)
+	}
+	conn := server.take(state.binary())
+	if conn == nil {
+		if conn, err = self.dial(ctx, state, server); err != nil {
+			self.fail(ctx, state, server, err)
+			return contextError(ctx, serverError(err, server))
+		}
+	}
+
+	conn.setDeadline(state.deadline(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		conn.setDeadline(time.Unix(1, 0))
+	})
+
+	err = fn(conn)
+	if !stop() {
+		conn.broken = true
+	}
+	if conn.broken || self.closed.Load() {
+		conn.Close()
+	} else {
+		server.put(conn, self.maxSize)
+	}
+	if conn.broken {
+		self.fail(ctx, state, server, err)
+	} else {
+		server.succeed()
+	}
+	if err != nil {
+		err = contextError(ctx, serverError(err, server))
+	}
+	return
+}
+
+// fail records a failure of server, unless ctx caused it, ejecting the server
+// from the continuum when due.
+func (self *nativeClient) fail(ctx context.Context, state *nativeState, server *nativeServer, err error) {
+	if ctx.Err() != nil {
+		server.setError(err)
+		return
+	}
+	if server.fail(state, err, time.Now()) && state.distribution() != DISTRIBUTION_MODULA {
+		self.update(func(*nativeState) error { return nil })
+	}
+}
+
+// withKey runs fn on a connection to the server of key, fn being given the
+// key as sent, namespace included.
+func (self *nativeClient) withKey(ctx context.Context, key string, fn func(*nativeConn, string) error) (err error) {
+	state := self.load()
+	if err = state.checkKey(key); err != nil {
+		return
+	}
+	server, err := state.server(key)
+	if err != nil {
+		return
+	}
+	release, err := self.checkout(ctx)
+	if err != nil {
+		return
+	}
+	defer release()
+	return self.exec(ctx, state, server, func(conn *nativeConn) error {
+		return fn(conn, state.namespace+key)
+	})
+}
+
+// eachServer runs fn against every server in turn and returns the first
+// error.
+func (self *nativeClient) eachServer(ctx context.Context, fn func(*nativeServer, *nativeConn) error) (err error) {
+	state := self.load()
+	release, err := self.checkout(ctx)
+	if err != nil {
+		return
+	}
+	defer release()
+	for _, server := range state.servers {
+		if e := self.exec(ctx, state, server, func(conn *nativeConn) error {
+			return fn(server, conn)
+		}); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func (self *nativeClient) AddServer(host string, port int, weight uint32) error {
+	server := newServerConfig(host, port, weight)
+	return self.update(func(state *nativeState) error {
+		if state.findServer(server.name()) >= 0 {
+			return ErrServerExists
+		}
+		state.servers = append(state.servers[:len(state.servers):len(state.servers)], &nativeServer{config: server})
+		return nil
+	})
+}
+
+func (self *nativeClient) RemoveServer(host string, port int) error {
+	return self.update(func(state *nativeState) error {
+		i := state.findServer(newServerConfig(host, port, 0).name())
+		if i < 0 {
+			return ErrUnknownServer
+		}
+		removed := state.servers[i]
+		servers := make([]*nativeServer, 0, len(state.servers)-1)
+		state.servers = append(append(servers, state.servers[:i]...), state.servers[i+1:]...)
+		removed.closeIdle()
+		return nil
+	})
+}
+
+// SetNamespace prefixes every key sent by the client with namespace, an empty
+// one removing the prefix. Keys read back are reported without it.
+func (self *nativeClient) SetNamespace(namespace string) error {
+	if err := checkNamespace(namespace); err != nil {
+		return err
+	}
+	return self.update(func(state *nativeState) error {
+		state.namespace = namespace
+		return nil
+	})
+}
+
+func (self *nativeClient) Namespace() string {
+	return self.state.Load().namespace
+}
+
+func (self *nativeClient) Servers() (servers []ServerInfo, err error) {
+	for _, server := range self.state.Load().servers {
+		servers = append(servers, server.info())
+	}
+	return
+}
+
+// GenerateHash returns the position of the server of key, as
+// memcached_generate_hash does.
+func (self *nativeClient) GenerateHash(key string) (uint32, error) {
+	return uint32(self.load().index(key)), nil
+}
+
+func (self *nativeClient) ServerForKey(key string) (info ServerInfo, err error) {
+	server, err := self.load().server(key)
+	if err != nil {
+		return
+	}
+	return server.info(), nil
+}
+
+// ServersForKeys groups keys by the name of the server they are hashed to.
+func (self *nativeClient) ServersForKeys(keys []string) (groups map[string][]string, err error) {
+	state := self.load()
+	groups = make(map[string][]string)
+	for _, key := range keys {
+		server, err := state.server(key)
+		if err != nil {
+			return nil, err
+		}
+		groups[server.name()] = append(groups[server.name()], key)
+	}
+	return
+}
+
+// Close closes the idle connections, those in use being closed once their
+// operation is over. Every later operation fails.
+// closedError is returned by the clients written in Go once closed.
+func closedError() error {
+	return &Error{Code: FAILURE, Message: "Client is closed"}
+}
+
+func (self *nativeClient) Close() {
+	self.closed.Store(true)
+	for _, server := range self.state.Load().servers {
+		server.closeIdle()
+	}
+}
@@ -0,0 +1,362 @@
+package gomc
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+const (
+	_BINARY_HEADER_SIZE = 24
+	_BINARY_REQUEST     = 0x80
+	_BINARY_RESPONSE    = 0x81
+
+	// Expiration telling incr and decr to fail on a missing key instead of
+	// creating it.
+	_BINARY_NO_INITIAL = 0xffffffff
+)
+
+const (
+	_OP_SET     = 0x01
+	_OP_ADD     = 0x02
+	_OP_REPLACE = 0x03
+	_OP_DELETE  = 0x04
+	_OP_INCR    = 0x05
+	_OP_DECR    = 0x06
+	_OP_FLUSH   = 0x08
+	_OP_NOOP    = 0x0a
+	_OP_VERSION = 0x0b
+	_OP_GETKQ   = 0x0d
+	_OP_APPEND  = 0x0e
+	_OP_PREPEND = 0x0f
+	_OP_STAT    = 0x10
+	_OP_SETQ    = 0x11
+	_OP_ADDQ    = 0x12
+	_OP_DELETEQ = 0x14
+	_OP_TOUCH   = 0x1c
+	_OP_GAT     = 0x1d
+)
+
+var binaryStoreCommands = map[storeCommand]byte{
+	_STORE_SET:     _OP_SET,
+	_STORE_ADD:     _OP_ADD,
+	_STORE_REPLACE: _OP_REPLACE,
+	_STORE_APPEND:  _OP_APPEND,
+	_STORE_PREPEND: _OP_PREPEND,
+	_STORE_CAS:     _OP_SET,
+}
+
+// Quiet commands only get a response on failure.
+var binaryQuietStoreCommands = map[storeCommand]byte{
+	_STORE_SET: _OP_SETQ,
+	_STORE_ADD: _OP_ADDQ,
+}
+
+var binaryStatuses = map[uint16]ReturnType{
+	0x01: NOTFOUND,
+	0x02: DATA_EXISTS,
+	0x03: E2BIG,
+	0x04: INVALID_ARGUMENTS,
+	0x05: NOTSTORED,
+	0x06: CLIENT_ERROR,
+	0x81: NOT_SUPPORTED,
+	0x82: SERVER_MEMORY_ALLOCATION_FAILURE,
+}
+
+type binaryRequest struct {
+	opcode byte
+	key    string
+	extras []byte
+	value  []byte
+	cas    uint64
+	opaque uint32
+}
+
+type binaryResponse struct {
+	opcode byte
+	status uint16
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    string
+	value  []byte
+}
+
+func (self *binaryResponse) err() error {
+	if self.status == 0 {
+		return nil
+	}
+	code, ok := binaryStatuses[self.status]
+	if !ok {
+		code = SERVER_ERROR
+	}
+	return &Error{Code: code, Message: string(self.value)}
+}
+
+func uint32Extras(values ...uint32) []byte {
+	extras := make([]byte, 4*len(values))
+	for i, value := range values {
+		binary.BigEndian.PutUint32(extras[4*i:], value)
+	}
+	return extras
+}
+
+type binaryProtocol struct{}
+
+func (binaryProtocol) write(conn *nativeConn, req binaryRequest) {
+	var header [_BINARY_HEADER_SIZE]byte
+	header[0] = _BINARY_REQUEST
+	header[1] = req.opcode
+	binary.BigEndian.PutUint16(header[2:], uint16(len(req.key)))
+	header[4] = byte(len(req.extras))
+	binary.BigEndian.PutUint32(header[8:], uint32(len(req.extras)+len(req.key)+len(req.value)))
+	binary.BigEndian.PutUint32(header[12:], req.opaque)
+	binary.BigEndian.PutUint64(header[16:], req.cas)
+
+	conn.writer.Write(header[:])
+	conn.writer.Write(req.extras)
+	conn.writer.WriteString(req.key)
+	conn.writer.Write(req.value)
+}
+
+func (binaryProtocol) read(conn *nativeConn) (res *binaryResponse, err error) {
+	var header [_BINARY_HEADER_SIZE]byte
+	if err = conn.readFull(header[:]); err != nil {
+		return
+	}
+	if header[0] != _BINARY_RESPONSE {
+		return nil, conn.protocolError("Invalid response magic " + strconv.Itoa(int(header[0])))
+	}
+	keyLength := int(binary.BigEndian.Uint16(header[2:]))
+	extrasLength := int(header[4])
+	bodyLength := int(binary.BigEndian.Uint32(header[8:]))
+	if keyLength+extrasLength > bodyLength {
+		return nil, conn.protocolError("Invalid response lengths")
+	}
+
+	body := make([]byte, bodyLength)
+	if err = conn.readFull(body); err != nil {
+		return
+	}
+	return &binaryResponse{
+		opcode: header[1],
+		status: binary.BigEndian.Uint16(header[6:]),
+		opaque: binary.BigEndian.Uint32(header[12:]),
+		cas:    binary.BigEndian.Uint64(header[16:]),
+		extras: body[:extrasLength],
+		key:    string(body[extrasLength : extrasLength+keyLength]),
+		value:  body[extrasLength+keyLength:],
+	}, nil
+}
+
+func (self binaryProtocol) call(conn *nativeConn, req binaryRequest) (res *binaryResponse, err error) {
+	self.write(conn, req)
+	if err = conn.flush(); err != nil {
+		return
+	}
+	if res, err = self.read(conn); err != nil {
+		return
+	}
+	if res.opcode != req.opcode {
+		return nil, conn.protocolError("Response to opcode " + strconv.Itoa(int(res.opcode)) + " out of sequence")
+	}
+	return res, res.err()
+}
+
+func (binaryProtocol) item(conn *nativeConn, res *binaryResponse, key string) (*Item, error) {
+	if len(res.extras) < 4 {
+		return nil, conn.protocolError("Missing flags in value of `" + key + "`")
+	}
+	return &Item{
+		Key:   key,
+		Value: res.value,
+		Flags: binary.BigEndian.Uint32(res.extras),
+		CAS:   res.cas,
+	}, nil
+}
+
+// get sends a quiet get per key and a noop whose response ends the batch,
+// misses getting no response at all. Every response is read even after fn
+// failed, so that the connection can be reused.
+func (self binaryProtocol) get(conn *nativeConn, keys []string, fn func(*Item) error) (err error) {
+	for i, key := range keys {
+		self.write(conn, binaryRequest{opcode: _OP_GETKQ, key: key, opaque: uint32(i)})
+	}
+	self.write(conn, binaryRequest{opcode: _OP_NOOP, opaque: uint32(len(keys))})
+	if err = conn.flush(); err != nil {
+		return
+	}
+
+	for {
+		res, e := self.read(conn)
+		if e != nil {
+			return e
+		}
+		if res.opcode == _OP_NOOP {
+			return
+		}
+		if res.opcode != _OP_GETKQ || res.opaque >= uint32(len(keys)) {
+			return conn.protocolError("Response to opcode " + strconv.Itoa(int(res.opcode)) + " out of sequence")
+		}
+		if err != nil {
+			continue
+		}
+		if err = res.err(); err != nil {
+			continue
+		}
+		item, e := self.item(conn, res, keys[res.opaque])
+		if e != nil {
+			return e
+		}
+		err = fn(item)
+	}
+}
+
+// flags gets the whole item, the binary protocol having no flags-only read.
+func (self binaryProtocol) flags(conn *nativeConn, key string) (uint32, error) {
+	return itemFlags(self, conn, key)
+}
+
+func (binaryProtocol) storeRequest(command storeCommand, item *Item, expiration uint32) binaryRequest {
+	req := binaryRequest{
+		opcode: binaryStoreCommands[command],
+		key:    item.Key,
+		value:  item.Value,
+	}
+	switch command {
+	case _STORE_CAS:
+		req.cas = item.CAS
+		fallthrough
+	case _STORE_SET, _STORE_ADD, _STORE_REPLACE:
+		req.extras = uint32Extras(item.Flags, expiration)
+	}
+	return req
+}
+
+func (self binaryProtocol) store(conn *nativeConn, command storeCommand, item *Item, expiration uint32) (err error) {
+	_, err = self.call(conn, self.storeRequest(command, item, expiration))
+	return
+}
+
+// storeMulti sends a quiet command per item, see quiet.
+func (self binaryProtocol) storeMulti(conn *nativeConn, command storeCommand, items []*Item, expiration uint32, failed func(int, error)) error {
+	opcode := binaryQuietStoreCommands[command]
+	for i, item := range items {
+		req := self.storeRequest(command, item, expiration)
+		req.opcode, req.opaque = opcode, uint32(i)
+		self.write(conn, req)
+	}
+	return self.quiet(conn, opcode, len(items), failed)
+}
+
+func (self binaryProtocol) deleteMulti(conn *nativeConn, keys []string, failed func(int, error)) error {
+	for i, key := range keys {
+		self.write(conn, binaryRequest{opcode: _OP_DELETEQ, key: key, opaque: uint32(i)})
+	}
+	return self.quiet(conn, _OP_DELETEQ, len(keys), failed)
+}
+
+// quiet ends n quiet commands written with their index as opaque by a noop,
+// and passes the index and error of the failed ones to failed, the others
+// getting no response at all.
+func (self binaryProtocol) quiet(conn *nativeConn, opcode byte, n int, failed func(int, error)) error {
+	self.write(conn, binaryRequest{opcode: _OP_NOOP, opaque: uint32(n)})
+	if err := conn.flush(); err != nil {
+		return err
+	}
+	for {
+		res, err := self.read(conn)
+		if err != nil {
+			return err
+		}
+		if res.opcode == _OP_NOOP {
+			return nil
+		}
+		if res.opcode != opcode || res.opaque >= uint32(n) {
+			return conn.protocolError("Response to opcode " + strconv.Itoa(int(res.opcode)) + " out of sequence")
+		}
+		if err = res.err(); err != nil {
+			failed(int(res.opaque), err)
+		}
+	}
+}
+
+func (self binaryProtocol) delete(conn *nativeConn, key string, expiration uint32) (err error) {
+	if expiration != 0 {
+		return &Error{Code: INVALID_ARGUMENTS, Message: "Delete with expiration is not supported by the binary protocol"}
+	}
+	_, err = self.call(conn, binaryRequest{opcode: _OP_DELETE, key: key})
+	return
+}
+
+func (self binaryProtocol) incr(conn *nativeConn, key string, decr bool, delta uint64) (uint64, error) {
+	return self.incrWithInitial(conn, key, decr, delta, 0, _BINARY_NO_INITIAL)
+}
+
+func (self binaryProtocol) incrWithInitial(conn *nativeConn, key string, decr bool, delta, initial uint64, expiration uint32) (value uint64, err error) {
+	req := binaryRequest{opcode: _OP_INCR, key: key, extras: make([]byte, 20)}
+	if decr {
+		req.opcode = _OP_DECR
+	}
+	binary.BigEndian.PutUint64(req.extras, delta)
+	binary.BigEndian.PutUint64(req.extras[8:], initial)
+	binary.BigEndian.PutUint32(req.extras[16:], expiration)
+
+	res, err := self.call(conn, req)
+	if err != nil {
+		return
+	}
+	if len(res.value) != 8 {
+		return 0, conn.protocolError("Invalid counter value of `" + key + "`")
+	}
+	return binary.BigEndian.Uint64(res.value), nil
+}
+
+func (self binaryProtocol) touch(conn *nativeConn, key string, expiration uint32) (err error) {
+	_, err = self.call(conn, binaryRequest{opcode: _OP_TOUCH, key: key, extras: uint32Extras(expiration)})
+	return
+}
+
+func (self binaryProtocol) getAndTouch(conn *nativeConn, key string, expiration uint32) (item *Item, err error) {
+	res, err := self.call(conn, binaryRequest{opcode: _OP_GAT, key: key, extras: uint32Extras(expiration)})
+	if err != nil {
+		return
+	}
+	return self.item(conn, res, key)
+}
+
+func (self binaryProtocol) flush(conn *nativeConn, expiration uint32) (err error) {
+	_, err = self.call(conn, binaryRequest{opcode: _OP_FLUSH, extras: uint32Extras(expiration)})
+	return
+}
+
+// stats reads one response per statistic, up to the one with an empty key.
+func (self binaryProtocol) stats(conn *nativeConn, args string, fn func(key, value string)) (err error) {
+	self.write(conn, binaryRequest{opcode: _OP_STAT, key: args})
+	if err = conn.flush(); err != nil {
+		return
+	}
+	for {
+		res, err := self.read(conn)
+		if err != nil {
+			return err
+		}
+		if res.opcode != _OP_STAT {
+			return conn.protocolError("Response to opcode " + strconv.Itoa(int(res.opcode)) + " out of sequence")
+		}
+		if err = res.err(); err != nil {
+			return err
+		}
+		if res.key == "" {
+			return nil
+		}
+		fn(res.key, string(res.value))
+	}
+}
+
+func (self binaryProtocol) version(conn *nativeConn) (version string, err error) {
+	res, err := self.call(conn, binaryRequest{opcode: _OP_VERSION})
+	if err != nil {
+		return
+	}
+	return string(res.value), nil
+}
@@ -0,0 +1,137 @@
+package gomc
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+type storeCommand int
+
+const (
+	_STORE_SET storeCommand = iota
+	_STORE_ADD
+	_STORE_REPLACE
+	_STORE_APPEND
+	_STORE_PREPEND
+	_STORE_CAS
+)
+
+// nativeProtocol speaks one of the memcached protocols over a connection.
+// Replies of the server are returned as *Error, while a failed connection is
+// marked broken so that it is not reused. The multi commands send all their
+// requests before reading the replies, callers bounding their number, and
+// pass the failures by index.
+type nativeProtocol interface {
+	get(conn *nativeConn, keys []string, fn func(*Item) error) error
+	flags(conn *nativeConn, key string) (uint32, error)
+	store(conn *nativeConn, command storeCommand, item *Item, expiration uint32) error
+	storeMulti(conn *nativeConn, command storeCommand, items []*Item, expiration uint32, failed func(int, error)) error
+	delete(conn *nativeConn, key string, expiration uint32) error
+	deleteMulti(conn *nativeConn, keys []string, failed func(int, error)) error
+	incr(conn *nativeConn, key string, decr bool, delta uint64) (uint64, error)
+	incrWithInitial(conn *nativeConn, key string, decr bool, delta, initial uint64, expiration uint32) (uint64, error)
+	touch(conn *nativeConn, key string, expiration uint32) error
+	getAndTouch(conn *nativeConn, key string, expiration uint32) (*Item, error)
+	flush(conn *nativeConn, expiration uint32) error
+	stats(conn *nativeConn, args string, fn func(key, value string)) error
+	version(conn *nativeConn) (string, error)
+}
+
+type nativeConn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	writer   *bufio.Writer
+	protocol nativeProtocol
+	binary   bool
+	broken   bool
+}
+
+func dialNative(ctx context.Context, dialer *net.Dialer, server serverConfig, binary, noDelay bool) (self *nativeConn, err error) {
+	network, address := "tcp", server.name()
+	if server.connection == CONNECTION_UNIX_SOCKET {
+		network = "unix"
+	}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, &Error{Code: connectionErrorCode(err), Message: err.Error()}
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(noDelay)
+	}
+
+	self = &nativeConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+		binary: binary,
+	}
+	self.protocol = textProtocol{}
+	if binary {
+		self.protocol = binaryProtocol{}
+	}
+	return
+}
+
+func connectionErrorCode(err error) ReturnType {
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return TIMEOUT
+	}
+	return CONNECTION_FAILURE
+}
+
+// fail marks the connection broken, the stream being left in an unknown
+// state.
+func (self *nativeConn) fail(code ReturnType, err error) error {
+	self.broken = true
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		code = TIMEOUT
+	} else if err == io.EOF || err == io.ErrUnexpectedEOF {
+		code = UNKNOWN_READ_FAILURE
+	}
+	return &Error{Code: code, Message: err.Error()}
+}
+
+func (self *nativeConn) protocolError(message string) error {
+	self.broken = true
+	return &Error{Code: PROTOCOL_ERROR, Message: message}
+}
+
+func (self *nativeConn) flush() error {
+	if err := self.writer.Flush(); err != nil {
+		return self.fail(WRITE_FAILURE, err)
+	}
+	return nil
+}
+
+func (self *nativeConn) readFull(buffer []byte) error {
+	if _, err := io.ReadFull(self.reader, buffer); err != nil {
+		return self.fail(READ_FAILURE, err)
+	}
+	return nil
+}
+
+func (self *nativeConn) setDeadline(deadline time.Time) {
+	self.conn.SetDeadline(deadline)
+}
+
+func (self *nativeConn) Close() error {
+	return self.conn.Close()
+}
+
+// itemFlags reads the flags of key from its whole item.
+func itemFlags(protocol nativeProtocol, conn *nativeConn, key string) (flags uint32, err error) {
+	found := false
+	if err = protocol.get(conn, []string{key}, func(item *Item) error {
+		flags, found = item.Flags, true
+		return nil
+	}); err != nil {
+		return
+	}
+	if !found {
+		return 0, &Error{Code: NOTFOUND, Message: "No result for key `" + key + "`"}
+	}
+	return
+}
@@ -0,0 +1,157 @@
+package gomc
+
+import (
+	"crypto/md5"
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+const (
+	_KETAMA_POINTS_PER_SERVER = 100
+)
+
+// The hash functions of libhashkit, so that the native backend sends each key
+// to the server libmemcached would pick.
+var nativeHashes = map[HashType]func([]byte) uint32{
+	HASH_DEFAULT:  hashOneAtATime,
+	HASH_MD5:      hashMD5,
+	HASH_CRC:      hashCRC,
+	HASH_FNV1_64:  hashFNV1_64,
+	HASH_FNV1A_64: hashFNV1A_64,
+	HASH_FNV1_32:  hashFNV1_32,
+	HASH_FNV1A_32: hashFNV1A_32,
+	HASH_MURMUR:   hashMurmur,
+}
+
+func hashOneAtATime(key []byte) (value uint32) {
+	for _, c := range key {
+		value += uint32(c)
+		value += value << 10
+		value ^= value >> 6
+	}
+	value += value << 3
+	value ^= value >> 11
+	value += value << 15
+	return
+}
+
+func hashMD5(key []byte) uint32 {
+	sum := md5.Sum(key)
+	return uint32(sum[3])<<24 | uint32(sum[2])<<16 | uint32(sum[1])<<8 | uint32(sum[0])
+}
+
+func hashCRC(key []byte) uint32 {
+	return (crc32.ChecksumIEEE(key) >> 16) & 0x7fff
+}
+
+const (
+	_FNV_64_INIT  = uint64(0xcbf29ce484222325)
+	_FNV_64_PRIME = uint64(0x100000001b3)
+	_FNV_32_INIT  = uint32(2166136261)
+	_FNV_32_PRIME = uint32(16777619)
+)
+
+func hashFNV1_64(key []byte) uint32 {
+	value := _FNV_64_INIT
+	for _, c := range key {
+		value *= _FNV_64_PRIME
+		value ^= uint64(c)
+	}
+	return uint32(value)
+}
+
+func hashFNV1A_64(key []byte) uint32 {
+	value := _FNV_64_INIT
+	for _, c := range key {
+		value ^= uint64(c)
+		value *= _FNV_64_PRIME
+	}
+	return uint32(value)
+}
+
+func hashFNV1_32(key []byte) uint32 {
+	value := _FNV_32_INIT
+	for _, c := range key {
+		value *= _FNV_32_PRIME
+		value ^= uint32(c)
+	}
+	return value
+}
+
+func hashFNV1A_32(key []byte) uint32 {
+	value := _FNV_32_INIT
+	for _, c := range key {
+		value ^= uint32(c)
+		value *= _FNV_32_PRIME
+	}
+	return value
+}
+
+// hashMurmur is MurmurHash2 seeded with the key length as in libhashkit.
+func hashMurmur(key []byte) uint32 {
+	const m = 0x5bd1e995
+	const r = 24
+
+	value := 0xdeadbeef*uint32(len(key)) ^ uint32(len(key))
+	for ; len(key) >= 4; key = key[4:] {
+		k := uint32(key[0]) | uint32(key[1])<<8 | uint32(key[2])<<16 | uint32(key[3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		value *= m
+		value ^= k
+	}
+	switch len(key) {
+	case 3:
+		value ^= uint32(key[2]) << 16
+		fallthrough
+	case 2:
+		value ^= uint32(key[1]) << 8
+		fallthrough
+	case 1:
+		value ^= uint32(key[0])
+		value *= m
+	}
+	value ^= value >> 13
+	value *= m
+	value ^= value >> 15
+	return value
+}
+
+type continuumPoint struct {
+	value uint32
+	index int
+}
+
+// newContinuum places each server at 100 points of the ketama ring, named as
+// libmemcached does when weights are not used.
+func newContinuum(servers []serverConfig, hash func([]byte) uint32) []continuumPoint {
+	points := make([]continuumPoint, 0, len(servers)*_KETAMA_POINTS_PER_SERVER)
+	for index, server := range servers {
+		name := server.host
+		if server.connection == CONNECTION_TCP && server.port != DEFAULT_PORT {
+			name += ":" + strconv.Itoa(server.port)
+		}
+		for i := 0; i < _KETAMA_POINTS_PER_SERVER; i++ {
+			points = append(points, continuumPoint{
+				value: hash([]byte(name + "-" + strconv.Itoa(i))),
+				index: index,
+			})
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].value < points[j].value
+	})
+	return points
+}
+
+func searchContinuum(points []continuumPoint, value uint32) int {
+	i := sort.Search(len(points), func(i int) bool {
+		return points[i].value >= value
+	})
+	if i == len(points) {
+		i = 0
+	}
+	return points[i].index
+}
@@ -0,0 +1,521 @@
+package gomc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	// Requests a batch writes to a server before reading their replies, so
+	// that the replies fit in the socket buffers while the requests are sent.
+	_NATIVE_BATCH_WINDOW = 100
+)
+
+func nativeExpiration(expiration time.Duration) uint32 {
+	return uint32(expiration / time.Second)
+}
+
+func (self *nativeClient) incr(ctx context.Context, key string, decr bool, offset uint32) (value uint64, err error) {
+	err = self.withKey(ctx, key, func(conn *nativeConn, key string) (err error) {
+		value, err = conn.protocol.incr(conn, key, decr, uint64(offset))
+		return
+	})
+	return
+}
+
+func (self *nativeClient) incrWithInitial(ctx context.Context, key string, decr bool, offset, initial uint64, expiration time.Duration) (value uint64, err error) {
+	err = self.withKey(ctx, key, func(conn *nativeConn, key string) (err error) {
+		value, err = conn.protocol.incrWithInitial(conn, key, decr, offset, initial, nativeExpiration(expiration))
+		return
+	})
+	return
+}
+
+func (self *nativeClient) IncrementContext(ctx context.Context, key string, offset uint32) (uint64, error) {
+	return self.incr(ctx, key, false, offset)
+}
+
+func (self *nativeClient) DecrementContext(ctx context.Context, key string, offset uint32) (uint64, error) {
+	return self.incr(ctx, key, true, offset)
+}
+
+// IncrementWithInitialContext and DecrementWithInitialContext need
+// BEHAVIOR_BINARY_PROTOCOL, as with libmemcached.
+func (self *nativeClient) IncrementWithInitialContext(ctx context.Context, key string, offset, initial uint64, expiration time.Duration) (uint64, error) {
+	return self.incrWithInitial(ctx, key, false, offset, initial, expiration)
+}
+
+func (self *nativeClient) DecrementWithInitialContext(ctx context.Context, key string, offset, initial uint64, expiration time.Duration) (uint64, error) {
+	return self.incrWithInitial(ctx, key, true, offset, initial, expiration)
+}
+
+func (self *nativeClient) DeleteContext(ctx context.Context, key string, expiration time.Duration) error {
+	return self.withKey(ctx, key, func(conn *nativeConn, key string) error {
+		return conn.protocol.delete(conn, key, nativeExpiration(expiration))
+	})
+}
+
+// getItem reads a single value, a miss being reported as NOTFOUND.
+func (self *nativeClient) getItem(ctx context.Context, key string) (item *Item, err error) {
+	err = self.withKey(ctx, key, func(conn *nativeConn, sent string) (err error) {
+		if err = conn.protocol.get(conn, []string{sent}, func(found *Item) error {
+			item = found
+			return nil
+		}); err != nil {
+			return
+		}
+		if item == nil {
+			return &Error{Code: NOTFOUND, Message: "No result for key `" + key + "`"}
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	item.Key = key
+	return
+}
+
+func (self *nativeClient) ExistContext(ctx context.Context, key string) (err error) {
+	_, err = self.getItem(ctx, key)
+	return
+}
+
+func (self *nativeClient) TouchContext(ctx context.Context, key string, expiration time.Duration) error {
+	return self.withKey(ctx, key, func(conn *nativeConn, key string) error {
+		return conn.protocol.touch(conn, key, nativeExpiration(expiration))
+	})
+}
+
+func (self *nativeClient) TouchMultiContext(ctx context.Context, keys []string, expiration time.Duration) error {
+	return eachKey(keys, func(key string) error {
+		return self.TouchContext(ctx, key, expiration)
+	})
+}
+
+func (self *nativeClient) FlushContext(ctx context.Context, expiration time.Duration) error {
+	return self.eachServer(ctx, func(server *nativeServer, conn *nativeConn) error {
+		return conn.protocol.flush(conn, nativeExpiration(expiration))
+	})
+}
+
+func (self *nativeClient) GetContext(ctx context.Context, key string, value interface{}) error {
+	item, err := self.getItem(ctx, key)
+	if err != nil {
+		return err
+	}
+	return item.Decode(value)
+}
+
+func (self *nativeClient) GetAndTouchContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	var item *Item
+	if err := self.withKey(ctx, key, func(conn *nativeConn, key string) (err error) {
+		item, err = conn.protocol.getAndTouch(conn, key, nativeExpiration(expiration))
+		return
+	}); err != nil {
+		return err
+	}
+	return item.Decode(value)
+}
+
+// getMulti reads keys server by server, passing each value to fn with the
+// namespace stripped from its key. The servers that failed are passed to
+// failed and, unless all of them failed, reported as SOME_ERRORS at the end.
+// The first error of fn stops the iteration and is returned.
+func (self *nativeClient) getMulti(ctx context.Context, keys []string, fn func(*Item) error, failed func(*nativeServer, []string, error)) (err error) {
+	state := self.load()
+	servers, groups, err := state.groupKeys(keys)
+	if err != nil {
+		return
+	}
+	release, err := self.checkout(ctx)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	var first error
+	failures := 0
+	for _, server := range servers {
+		sent := make([]string, len(groups[server]))
+		for i, key := range groups[server] {
+			sent[i] = state.namespace + key
+		}
+
+		var fnErr error
+		e := self.exec(ctx, state, server, func(conn *nativeConn) error {
+			return conn.protocol.get(conn, sent, func(item *Item) error {
+				item.Key = strings.TrimPrefix(item.Key, state.namespace)
+				fnErr = fn(item)
+				return fnErr
+			})
+		})
+		if fnErr != nil {
+			return fnErr
+		}
+		if e != nil {
+			if ctx.Err() != nil {
+				return e
+			}
+			failed(server, groups[server], e)
+			if first == nil {
+				first = e
+			}
+			failures++
+		}
+	}
+	if failures > 0 && failures == len(servers) {
+		return first
+	}
+	if failures > 0 {
+		return &Error{Code: SOME_ERRORS}
+	}
+	return
+}
+
+func (self *nativeClient) GetMultiContext(ctx context.Context, keys []string) (Result, error) {
+	res := newResult(keys)
+	err := self.getMulti(ctx, keys, func(item *Item) error {
+		res.set(item)
+		return nil
+	}, func(server *nativeServer, keys []string, err error) {
+		for _, key := range keys {
+			res.setServerError(key, server.name(), err)
+		}
+	})
+	if err != nil && !errors.Is(err, ErrSomeErrors) {
+		return nil, err
+	}
+	return res, err
+}
+
+// GetMultiFuncContext requests keys in batches of 1000, see
+// Client.GetMultiFunc.
+func (self *nativeClient) GetMultiFuncContext(ctx context.Context, keys []string, fn func(*Item) error) (err error) {
+	var partial error
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > _GET_MULTI_BATCH_SIZE {
+			batch = batch[:_GET_MULTI_BATCH_SIZE]
+		}
+		keys = keys[len(batch):]
+
+		err = self.getMulti(ctx, batch, fn, func(*nativeServer, []string, error) {})
+		if errors.Is(err, ErrSomeErrors) {
+			partial = err
+		} else if err != nil {
+			return
+		}
+	}
+	return partial
+}
+
+func (self *nativeClient) GetWithCASContext(ctx context.Context, key string, value interface{}) (cas uint64, err error) {
+	item, err := self.getItem(ctx, key)
+	if err != nil {
+		return
+	}
+	return item.CAS, item.Decode(value)
+}
+
+func (self *nativeClient) store(ctx context.Context, command storeCommand, key string, value interface{}, cas uint64, expiration time.Duration) error {
+	buffer, flag, err := encode(value, self.encoding)
+	if err != nil {
+		return err
+	}
+	if (command == _STORE_APPEND || command == _STORE_PREPEND) && !appendable(flag) {
+		return ErrNotAppendable
+	}
+	return self.withKey(ctx, key, func(conn *nativeConn, key string) error {
+		// Appended data is checked against the flags of the stored value,
+		// which an encoded value can still replace before the append.
+		if command == _STORE_APPEND || command == _STORE_PREPEND {
+			if err := checkAppendable(conn.protocol.flags(conn, key)); err != nil {
+				return err
+			}
+		}
+		return conn.protocol.store(conn, command, &Item{Key: key, Value: buffer, Flags: flag, CAS: cas}, nativeExpiration(expiration))
+	})
+}
+
+func (self *nativeClient) AddContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.store(ctx, _STORE_ADD, key, value, 0, expiration)
+}
+
+func (self *nativeClient) ReplaceContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.store(ctx, _STORE_REPLACE, key, value, 0, expiration)
+}
+
+func (self *nativeClient) SetContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.store(ctx, _STORE_SET, key, value, 0, expiration)
+}
+
+func (self *nativeClient) AppendContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.store(ctx, _STORE_APPEND, key, value, 0, expiration)
+}
+
+func (self *nativeClient) PrependContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return self.store(ctx, _STORE_PREPEND, key, value, 0, expiration)
+}
+
+func (self *nativeClient) CompareAndSwapContext(ctx context.Context, key string, value interface{}, cas uint64, expiration time.Duration) error {
+	return self.store(ctx, _STORE_CAS, key, value, cas, expiration)
+}
+
+// batch sends the keys server by server through op, which gets them with
+// the namespace prepended and reports the failed ones by index. op is given
+// at most _NATIVE_BATCH_WINDOW keys at once, lest the client and the server
+// both block writing to each other. Invalid keys and the keys of a failed
+// server are recorded in errs as well.
+func (self *nativeClient) batch(ctx context.Context, keys []string, errs MultiError, op func(conn *nativeConn, keys, sent []string, failed func(int, error)) error) error {
+	state := self.load()
+	valid := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if err := state.checkKey(key); err != nil {
+			errs[key] = err
+		} else {
+			valid = append(valid, key)
+		}
+	}
+	servers, groups, err := state.groupKeys(valid)
+	if err != nil {
+		return err
+	}
+	release, err := self.checkout(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for _, server := range servers {
+		group := groups[server]
+		sent := make([]string, len(group))
+		for i, key := range group {
+			sent[i] = state.namespace + key
+		}
+		err := self.exec(ctx, state, server, func(conn *nativeConn) error {
+			for start := 0; start < len(group); start += _NATIVE_BATCH_WINDOW {
+				end := start + _NATIVE_BATCH_WINDOW
+				if end > len(group) {
+					end = len(group)
+				}
+				window := group[start:end]
+				if err := op(conn, window, sent[start:end], func(i int, err error) {
+					errs[window[i]] = serverError(err, server)
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			for _, key := range group {
+				if _, ok := errs[key]; !ok {
+					errs[key] = err
+				}
+			}
+		}
+	}
+	return errs.err()
+}
+
+func (self *nativeClient) storeMulti(ctx context.Context, command storeCommand, items map[string]interface{}, expiration time.Duration) error {
+	errs := make(MultiError)
+	encoded := make(map[string]*Item, len(items))
+	keys := make([]string, 0, len(items))
+	for key, value := range items {
+		buffer, flag, err := encode(value, self.encoding)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		encoded[key] = &Item{Value: buffer, Flags: flag}
+		keys = append(keys, key)
+	}
+	return self.batch(ctx, keys, errs, func(conn *nativeConn, keys, sent []string, failed func(int, error)) error {
+		batch := make([]*Item, len(keys))
+		for i, key := range keys {
+			batch[i] = &Item{Key: sent[i], Value: encoded[key].Value, Flags: encoded[key].Flags}
+		}
+		return conn.protocol.storeMulti(conn, command, batch, nativeExpiration(expiration), failed)
+	})
+}
+
+func (self *nativeClient) SetMultiContext(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	return self.storeMulti(ctx, _STORE_SET, items, expiration)
+}
+
+func (self *nativeClient) AddMultiContext(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	return self.storeMulti(ctx, _STORE_ADD, items, expiration)
+}
+
+func (self *nativeClient) DeleteMultiContext(ctx context.Context, keys []string) error {
+	return self.batch(ctx, keys, make(MultiError), func(conn *nativeConn, keys, sent []string, failed func(int, error)) error {
+		return conn.protocol.deleteMulti(conn, sent, failed)
+	})
+}
+
+// StatsContext runs `stats <args>` against every server, see Client.Stats.
+func (self *nativeClient) StatsContext(ctx context.Context, args string) (stats map[string]ServerStats, err error) {
+	collector := make(statsCollector)
+	err = self.eachServer(ctx, func(server *nativeServer, conn *nativeConn) error {
+		return conn.protocol.stats(conn, args, func(key, value string) {
+			collector.add(server.name(), key, value)
+		})
+	})
+	return collector.result(), err
+}
+
+func (self *nativeClient) VersionsContext(ctx context.Context) (versions map[string]string, err error) {
+	versions = make(map[string]string)
+	err = self.eachServer(ctx, func(server *nativeServer, conn *nativeConn) error {
+		version, err := conn.protocol.version(conn)
+		if err == nil {
+			versions[server.name()] = version
+		}
+		return err
+	})
+	return
+}
+
+// ping sends a version request over a new connection, so that the latency
+// includes connecting as with libmemcached.
+func (self *nativeClient) ping(ctx context.Context, state *nativeState, server *nativeServer) (res PingResult) {
+	start := time.Now()
+	conn, err := self.dial(ctx, state, server)
+	if err == nil {
+		conn.setDeadline(state.deadline(ctx))
+		_, err = conn.protocol.version(conn)
+		conn.Close()
+	}
+	res.Latency = time.Since(start)
+	if err != nil {
+		res.Err = contextError(ctx, serverError(err, server))
+	}
+	return
+}
+
+// PingContext probes every server one by one, see Client.Ping.
+func (self *nativeClient) PingContext(ctx context.Context) (res map[string]PingResult, err error) {
+	state := self.load()
+	release, err := self.checkout(ctx)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	res = make(map[string]PingResult)
+	for _, server := range state.servers {
+		res[server.name()] = self.ping(ctx, state, server)
+		if res[server.name()].Err != nil && err == nil {
+			err = res[server.name()].Err
+		}
+	}
+	return
+}
+
+func (self *nativeClient) Increment(key string, offset uint32) (uint64, error) {
+	return self.IncrementContext(context.Background(), key, offset)
+}
+
+func (self *nativeClient) Decrement(key string, offset uint32) (uint64, error) {
+	return self.DecrementContext(context.Background(), key, offset)
+}
+
+func (self *nativeClient) IncrementWithInitial(key string, offset, initial uint64, expiration time.Duration) (uint64, error) {
+	return self.IncrementWithInitialContext(context.Background(), key, offset, initial, expiration)
+}
+
+func (self *nativeClient) DecrementWithInitial(key string, offset, initial uint64, expiration time.Duration) (uint64, error) {
+	return self.DecrementWithInitialContext(context.Background(), key, offset, initial, expiration)
+}
+
+func (self *nativeClient) Delete(key string, expiration time.Duration) error {
+	return self.DeleteContext(context.Background(), key, expiration)
+}
+
+func (self *nativeClient) Exist(key string) error {
+	return self.ExistContext(context.Background(), key)
+}
+
+func (self *nativeClient) Touch(key string, expiration time.Duration) error {
+	return self.TouchContext(context.Background(), key, expiration)
+}
+
+func (self *nativeClient) TouchMulti(keys []string, expiration time.Duration) error {
+	return self.TouchMultiContext(context.Background(), keys, expiration)
+}
+
+func (self *nativeClient) Flush(expiration time.Duration) error {
+	return self.FlushContext(context.Background(), expiration)
+}
+
+func (self *nativeClient) Get(key string, value interface{}) error {
+	return self.GetContext(context.Background(), key, value)
+}
+
+func (self *nativeClient) GetAndTouch(key string, value interface{}, expiration time.Duration) error {
+	return self.GetAndTouchContext(context.Background(), key, value, expiration)
+}
+
+func (self *nativeClient) GetMulti(keys []string) (Result, error) {
+	return self.GetMultiContext(context.Background(), keys)
+}
+
+func (self *nativeClient) GetMultiFunc(keys []string, fn func(*Item) error) error {
+	return self.GetMultiFuncContext(context.Background(), keys, fn)
+}
+
+func (self *nativeClient) GetWithCAS(key string, value interface{}) (uint64, error) {
+	return self.GetWithCASContext(context.Background(), key, value)
+}
+
+func (self *nativeClient) Add(key string, value interface{}, expiration time.Duration) error {
+	return self.AddContext(context.Background(), key, value, expiration)
+}
+
+func (self *nativeClient) Replace(key string, value interface{}, expiration time.Duration) error {
+	return self.ReplaceContext(context.Background(), key, value, expiration)
+}
+
+func (self *nativeClient) Set(key string, value interface{}, expiration time.Duration) error {
+	return self.SetContext(context.Background(), key, value, expiration)
+}
+
+func (self *nativeClient) Append(key string, value interface{}, expiration time.Duration) error {
+	return self.AppendContext(context.Background(), key, value, expiration)
+}
+
+func (self *nativeClient) Prepend(key string, value interface{}, expiration time.Duration) error {
+	return self.PrependContext(context.Background(), key, value, expiration)
+}
+
+func (self *nativeClient) CompareAndSwap(key string, value interface{}, cas uint64, expiration time.Duration) error {
+	return self.CompareAndSwapContext(context.Background(), key, value, cas, expiration)
+}
+
+func (self *nativeClient) SetMulti(items map[string]interface{}, expiration time.Duration) error {
+	return self.SetMultiContext(context.Background(), items, expiration)
+}
+
+func (self *nativeClient) AddMulti(items map[string]interface{}, expiration time.Duration) error {
+	return self.AddMultiContext(context.Background(), items, expiration)
+}
+
+func (self *nativeClient) DeleteMulti(keys []string) error {
+	return self.DeleteMultiContext(context.Background(), keys)
+}
+
+func (self *nativeClient) Stats(args string) (map[string]ServerStats, error) {
+	return self.StatsContext(context.Background(), args)
+}
+
+func (self *nativeClient) Versions() (map[string]string, error) {
+	return self.VersionsContext(context.Background())
+}
+
+func (self *nativeClient) Ping() (map[string]PingResult, error) {
+	return self.PingContext(context.Background())
+}
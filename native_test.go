@@ -0,0 +1,172 @@
+package gomc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ianoshen/gomc/gomctest"
+)
+
+func TestNativeHash(t *testing.T) {
+	tests := []struct {
+		hash  HashType
+		key   string
+		value uint32
+	}{
+		{HASH_DEFAULT, "a", 0xca2e9442},
+		{HASH_MD5, "hello", 0x2a40415d},
+		{HASH_CRC, "hello", 0x3610},
+		{HASH_FNV1_64, "hello", 0xbdbdd4c7},
+		{HASH_FNV1A_64, "hello", 0x80aabd0b},
+		{HASH_FNV1_32, "hello", 0xb6fa7167},
+		{HASH_FNV1A_32, "hello", 0x4f9f2cab},
+		{HASH_MURMUR, "hello", 0x521b0bff},
+	}
+	for _, test := range tests {
+		if value := nativeHashes[test.hash]([]byte(test.key)); value != test.value {
+			t.Errorf("Error hash %d of %s: %x, expect: %x", test.hash, test.key, value, test.value)
+		}
+	}
+}
+
+func TestNativeContinuum(t *testing.T) {
+	servers := parseServers(testHosts)
+	points := newContinuum(servers, hashMD5)
+	if len(points) != len(servers)*_KETAMA_POINTS_PER_SERVER {
+		t.Error("Error continuum size:", len(points))
+	}
+	for i := 1; i < len(points); i++ {
+		if points[i-1].value > points[i].value {
+			t.Error("Error continuum order at:", i)
+		}
+	}
+	if index := searchContinuum(points, points[len(points)-1].value+1); index != points[0].index {
+		t.Error("Error continuum wrap:", index, ", expect:", points[0].index)
+	}
+}
+
+func TestNativeBehavior(t *testing.T) {
+	mc, err := newNative(parseServers(testHosts), 1, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+	if timeout, _ := mc.GetBehavior(BEHAVIOR_POLL_TIMEOUT); timeout != _NATIVE_POLL_TIMEOUT {
+		t.Error("Error poll timeout:", timeout)
+	}
+	if err = mc.SetBehavior(BEHAVIOR_USE_UDP, 1); !errors.Is(err, ErrNotSupported) {
+		t.Error("Error set udp:", err, ", expect:", ErrNotSupported)
+	}
+	if err = mc.SetBehavior(BEHAVIOR_NOREPLY, 1); !errors.Is(err, ErrNotSupported) {
+		t.Error("Error set noreply:", err, ", expect:", ErrNotSupported)
+	}
+	if err = mc.SetBehavior(BEHAVIOR_HASH, uint64(HASH_HSIEH)); !errors.Is(err, ErrNotSupported) {
+		t.Error("Error set hash:", err, ", expect:", ErrNotSupported)
+	}
+	if err = mc.SetBehavior(BEHAVIOR_DISTRIBUTION, uint64(DISTRIBUTION_CONSISTENT_KETAMA)); err != nil {
+		t.Error("Fail to set distribution:", err)
+	}
+	if err = mc.Set("foo bar", "baz", 0); !errors.Is(err, ErrBadKey) {
+		t.Error("Error text key:", err, ", expect:", ErrBadKey)
+	}
+}
+
+func TestNativeProtocols(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	for _, binary := range []uint64{0, 1} {
+		mc, err := newNative(parseServers(testHosts), 2, ENCODING_DEFAULT)
+		if err != nil {
+			t.Error("Fail to new client:", err)
+		}
+		if err = mc.SetBehavior(BEHAVIOR_BINARY_PROTOCOL, binary); err != nil {
+			t.Error("Fail to set behavior:", err)
+		}
+		if err = mc.SetNamespace("app:"); err != nil {
+			t.Error("Fail to set namespace:", err)
+		}
+
+		if err = mc.Set("foo", "bar", 0); err != nil {
+			t.Error("Fail to set:", err)
+		}
+		if err = mc.Append("foo", "baz", 0); err != nil {
+			t.Error("Fail to append:", err)
+		}
+		var value string
+		cas, err := mc.GetWithCAS("foo", &value)
+		if err != nil || value != "barbaz" {
+			t.Error("Error get:", value, err)
+		}
+		if err = mc.CompareAndSwap("foo", "qux", cas+1, 0); !errors.Is(err, ErrCASConflict) {
+			t.Error("Error compare and swap:", err, ", expect:", ErrCASConflict)
+		}
+		if err = mc.Add("foo", "qux", 0); !errors.Is(err, ErrNotStored) {
+			t.Error("Error add:", err, ", expect:", ErrNotStored)
+		}
+
+		mc.Set("num", 1, 0)
+		if num, err := mc.Increment("num", 2); err != nil || num != 3 {
+			t.Error("Error increment:", num, err)
+		}
+		if _, err = mc.Increment("missing", 1); !errors.Is(err, ErrNotFound) {
+			t.Error("Error increment missing:", err, ", expect:", ErrNotFound)
+		}
+
+		res, err := mc.GetMulti([]string{"foo", "num", "missing"})
+		if err != nil || res.Len() != 2 {
+			t.Error("Error get multi:", res, err)
+		} else if missing := res.Missing(); len(missing) != 1 || missing[0] != "missing" {
+			t.Error("Error missing keys:", missing)
+		}
+
+		if err = mc.Delete("foo", 0); err != nil {
+			t.Error("Fail to delete:", err)
+		}
+		if err = mc.Exist("foo"); !errors.Is(err, ErrNotFound) {
+			t.Error("Error exist:", err, ", expect:", ErrNotFound)
+		}
+		if versions, err := mc.Versions(); err != nil || len(versions) != len(testHosts) {
+			t.Error("Error versions:", versions, err)
+		}
+		mc.Close()
+	}
+}
+
+func TestNativeCheckoutTimeout(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	mc, err := newNative(parseServers(testHosts), 1, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+	pool := mc.(*nativeClient)
+
+	release, err := pool.checkout(context.Background())
+	if err != nil {
+		t.Error("Fail to check out:", err)
+	}
+
+	if err = pool.SetContext(NoWait(context.Background()), "test-key", "test-value", 0); err != ErrPoolExhausted {
+		t.Error("Error set:", err, ", expect:", ErrPoolExhausted)
+	}
+
+	pool.SetCheckoutTimeout(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = pool.SetContext(ctx, "test-key", "test-value", 0); err != context.DeadlineExceeded {
+		t.Error("Error set:", err, ", expect:", context.DeadlineExceeded)
+	}
+
+	if stats := pool.PoolStats(); stats.InUse != 1 || stats.Waits != 2 || stats.Timeouts != 1 {
+		t.Error("Error pool stats:", stats)
+	}
+
+	release()
+	if err = pool.Set("test-key", "test-value", 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	if stats := pool.PoolStats(); stats.InUse != 0 {
+		t.Error("Error in use:", stats.InUse, ", expect:", 0)
+	}
+}
@@ -0,0 +1,371 @@
+package gomc
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	_TEXT_CRLF = "\r\n"
+
+	_TEXT_VALUE   = "VALUE"
+	_TEXT_END     = "END"
+	_TEXT_STAT    = "STAT"
+	_TEXT_VERSION = "VERSION"
+
+	_TEXT_META_HIT  = "HD"
+	_TEXT_META_MISS = "EN"
+
+	_TEXT_STORED     = "STORED"
+	_TEXT_DELETED    = "DELETED"
+	_TEXT_TOUCHED    = "TOUCHED"
+	_TEXT_OK         = "OK"
+	_TEXT_NOT_FOUND  = "NOT_FOUND"
+	_TEXT_NOT_STORED = "NOT_STORED"
+	_TEXT_EXISTS     = "EXISTS"
+
+	_TEXT_ERROR        = "ERROR"
+	_TEXT_CLIENT_ERROR = "CLIENT_ERROR"
+	_TEXT_SERVER_ERROR = "SERVER_ERROR"
+
+	_TEXT_TOO_LARGE     = "object too large for cache"
+	_TEXT_OUT_OF_MEMORY = "out of memory storing object"
+)
+
+var textStoreCommands = map[storeCommand]string{
+	_STORE_SET:     "set",
+	_STORE_ADD:     "add",
+	_STORE_REPLACE: "replace",
+	_STORE_APPEND:  "append",
+	_STORE_PREPEND: "prepend",
+	_STORE_CAS:     "cas",
+}
+
+// checkTextKey rejects the keys the text protocol can not carry, which
+// libmemcached only does with BEHAVIOR_VERIFY_KEY.
+func checkTextKey(key string) error {
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return &Error{Code: BAD_KEY_PROVIDED, Message: "Key `" + key + "` contains whitespace or control characters"}
+		}
+	}
+	return nil
+}
+
+type textProtocol struct{}
+
+func (textProtocol) write(conn *nativeConn, fields ...string) {
+	conn.writer.WriteString(strings.Join(fields, " "))
+	conn.writer.WriteString(_TEXT_CRLF)
+}
+
+func (self textProtocol) send(conn *nativeConn, fields ...string) error {
+	self.write(conn, fields...)
+	return conn.flush()
+}
+
+func (textProtocol) readLine(conn *nativeConn) (line string, err error) {
+	line, e := conn.reader.ReadString('\n')
+	if e != nil {
+		return "", conn.fail(READ_FAILURE, e)
+	}
+	if !strings.HasSuffix(line, _TEXT_CRLF) {
+		return "", conn.protocolError("Reply line not terminated by CRLF")
+	}
+	return line[:len(line)-len(_TEXT_CRLF)], nil
+}
+
+// reply maps a line other than the expected one to an error. An unknown
+// command or line leaves the stream in an unknown state.
+func (textProtocol) reply(conn *nativeConn, line string) error {
+	switch line {
+	case _TEXT_NOT_FOUND:
+		return &Error{Code: NOTFOUND, Message: line}
+	case _TEXT_NOT_STORED:
+		return &Error{Code: NOTSTORED, Message: line}
+	case _TEXT_EXISTS:
+		return &Error{Code: DATA_EXISTS, Message: line}
+	case _TEXT_ERROR:
+		return conn.protocolError("Command rejected by server")
+	}
+	if message, ok := strings.CutPrefix(line, _TEXT_CLIENT_ERROR+" "); ok {
+		return &Error{Code: CLIENT_ERROR, Message: message}
+	}
+	if message, ok := strings.CutPrefix(line, _TEXT_SERVER_ERROR+" "); ok {
+		switch message {
+		case _TEXT_TOO_LARGE:
+			return &Error{Code: E2BIG, Message: message}
+		case _TEXT_OUT_OF_MEMORY:
+			return &Error{Code: SERVER_MEMORY_ALLOCATION_FAILURE, Message: message}
+		}
+		return &Error{Code: SERVER_ERROR, Message: message}
+	}
+	return conn.protocolError("Unexpected reply `" + line + "`")
+}
+
+func (self textProtocol) expect(conn *nativeConn, expected string) error {
+	line, err := self.readLine(conn)
+	if err != nil {
+		return err
+	}
+	if line != expected {
+		return self.reply(conn, line)
+	}
+	return nil
+}
+
+// readValue reads the data block announced by a `VALUE <key> <flags> <bytes>
+// [<cas>]` line.
+func (self textProtocol) readValue(conn *nativeConn, line string) (item *Item, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || len(fields) > 5 || fields[0] != _TEXT_VALUE {
+		return nil, self.reply(conn, line)
+	}
+	flags, e1 := strconv.ParseUint(fields[2], _NUMERIC_BASE, 32)
+	size, e2 := strconv.ParseUint(fields[3], _NUMERIC_BASE, 31)
+	if e1 != nil || e2 != nil {
+		return nil, conn.protocolError("Malformed value line `" + line + "`")
+	}
+	item = &Item{Key: fields[1], Flags: uint32(flags)}
+	if len(fields) == 5 {
+		if item.CAS, err = strconv.ParseUint(fields[4], _NUMERIC_BASE, 64); err != nil {
+			return nil, conn.protocolError("Malformed value line `" + line + "`")
+		}
+	}
+
+	buffer := make([]byte, size+uint64(len(_TEXT_CRLF)))
+	if err = conn.readFull(buffer); err != nil {
+		return nil, err
+	}
+	if string(buffer[size:]) != _TEXT_CRLF {
+		return nil, conn.protocolError("Value of `" + item.Key + "` not terminated by CRLF")
+	}
+	item.Value = buffer[:size]
+	return
+}
+
+// get reads every value up to END even after fn failed, so that the
+// connection can be reused, and returns the first error of fn.
+func (self textProtocol) get(conn *nativeConn, keys []string, fn func(*Item) error) (err error) {
+	if err = self.send(conn, append([]string{"gets"}, keys...)...); err != nil {
+		return
+	}
+	for {
+		line, e := self.readLine(conn)
+		if e != nil {
+			return e
+		}
+		if line == _TEXT_END {
+			return
+		}
+		item, e := self.readValue(conn, line)
+		if e != nil {
+			return e
+		}
+		if err == nil {
+			err = fn(item)
+		}
+	}
+}
+
+// flags reads the flags of key alone with the meta get of memcached 1.6,
+// getting the whole item from the older servers, which reject it.
+func (self textProtocol) flags(conn *nativeConn, key string) (flags uint32, err error) {
+	if err = self.send(conn, "mg", key, "f"); err != nil {
+		return
+	}
+	line, err := self.readLine(conn)
+	if err != nil {
+		return
+	}
+	switch line {
+	case _TEXT_META_MISS:
+		return 0, &Error{Code: NOTFOUND, Message: _TEXT_NOT_FOUND}
+	case _TEXT_ERROR:
+		return itemFlags(self, conn, key)
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 2 || fields[0] != _TEXT_META_HIT || !strings.HasPrefix(fields[1], "f") {
+		return 0, self.reply(conn, line)
+	}
+	value, e := strconv.ParseUint(fields[1][1:], _NUMERIC_BASE, 32)
+	if e != nil {
+		return 0, conn.protocolError("Malformed meta line `" + line + "`")
+	}
+	return uint32(value), nil
+}
+
+func (self textProtocol) writeStore(conn *nativeConn, command storeCommand, item *Item, expiration uint32) {
+	fields := []string{
+		textStoreCommands[command],
+		item.Key,
+		strconv.FormatUint(uint64(item.Flags), _NUMERIC_BASE),
+		strconv.FormatUint(uint64(expiration), _NUMERIC_BASE),
+		strconv.Itoa(len(item.Value)),
+	}
+	if command == _STORE_CAS {
+		fields = append(fields, strconv.FormatUint(item.CAS, _NUMERIC_BASE))
+	}
+	self.write(conn, fields...)
+	conn.writer.Write(item.Value)
+	conn.writer.WriteString(_TEXT_CRLF)
+}
+
+func (self textProtocol) store(conn *nativeConn, command storeCommand, item *Item, expiration uint32) error {
+	self.writeStore(conn, command, item, expiration)
+	if err := conn.flush(); err != nil {
+		return err
+	}
+	return self.expect(conn, _TEXT_STORED)
+}
+
+// storeMulti writes every command before reading the replies, which come in
+// the same order.
+func (self textProtocol) storeMulti(conn *nativeConn, command storeCommand, items []*Item, expiration uint32, failed func(int, error)) error {
+	for _, item := range items {
+		self.writeStore(conn, command, item, expiration)
+	}
+	if err := conn.flush(); err != nil {
+		return err
+	}
+	return self.expectEach(conn, len(items), _TEXT_STORED, failed)
+}
+
+// expectEach reads the replies of n pipelined commands, passing the index and
+// error of the unexpected ones to failed. Reading stops once the connection
+// is broken.
+func (self textProtocol) expectEach(conn *nativeConn, n int, expected string, failed func(int, error)) error {
+	for i := 0; i < n; i++ {
+		if err := self.expect(conn, expected); err != nil {
+			if conn.broken {
+				return err
+			}
+			failed(i, err)
+		}
+	}
+	return nil
+}
+
+func (self textProtocol) delete(conn *nativeConn, key string, expiration uint32) (err error) {
+	if expiration == 0 {
+		err = self.send(conn, "delete", key)
+	} else {
+		err = self.send(conn, "delete", key, strconv.FormatUint(uint64(expiration), _NUMERIC_BASE))
+	}
+	if err != nil {
+		return
+	}
+	return self.expect(conn, _TEXT_DELETED)
+}
+
+func (self textProtocol) deleteMulti(conn *nativeConn, keys []string, failed func(int, error)) error {
+	for _, key := range keys {
+		self.write(conn, "delete", key)
+	}
+	if err := conn.flush(); err != nil {
+		return err
+	}
+	return self.expectEach(conn, len(keys), _TEXT_DELETED, failed)
+}
+
+func (self textProtocol) incr(conn *nativeConn, key string, decr bool, delta uint64) (value uint64, err error) {
+	command := "incr"
+	if decr {
+		command = "decr"
+	}
+	if err = self.send(conn, command, key, strconv.FormatUint(delta, _NUMERIC_BASE)); err != nil {
+		return
+	}
+	line, err := self.readLine(conn)
+	if err != nil {
+		return
+	}
+	if value, e := strconv.ParseUint(strings.TrimSpace(line), _NUMERIC_BASE, 64); e == nil {
+		return value, nil
+	}
+	return 0, self.reply(conn, line)
+}
+
+func (textProtocol) incrWithInitial(conn *nativeConn, key string, decr bool, delta, initial uint64, expiration uint32) (uint64, error) {
+	return 0, &Error{Code: NOT_SUPPORTED, Message: "Initial values require the binary protocol"}
+}
+
+func (self textProtocol) touch(conn *nativeConn, key string, expiration uint32) error {
+	if err := self.send(conn, "touch", key, strconv.FormatUint(uint64(expiration), _NUMERIC_BASE)); err != nil {
+		return err
+	}
+	return self.expect(conn, _TEXT_TOUCHED)
+}
+
+// The text protocol of the servers libmemcached 1.0.18 targets has no gat,
+// so the touch is issued first as the libmemcached backend does.
+func (self textProtocol) getAndTouch(conn *nativeConn, key string, expiration uint32) (item *Item, err error) {
+	if err = self.touch(conn, key, expiration); err != nil {
+		return
+	}
+	if err = self.get(conn, []string{key}, func(found *Item) error {
+		item = found
+		return nil
+	}); err != nil {
+		return
+	}
+	if item == nil {
+		err = &Error{Code: NOTFOUND, Message: _TEXT_NOT_FOUND}
+	}
+	return
+}
+
+func (self textProtocol) flush(conn *nativeConn, expiration uint32) (err error) {
+	if expiration == 0 {
+		err = self.send(conn, "flush_all")
+	} else {
+		err = self.send(conn, "flush_all", strconv.FormatUint(uint64(expiration), _NUMERIC_BASE))
+	}
+	if err != nil {
+		return
+	}
+	return self.expect(conn, _TEXT_OK)
+}
+
+func (self textProtocol) stats(conn *nativeConn, args string, fn func(key, value string)) (err error) {
+	if args == "" {
+		err = self.send(conn, "stats")
+	} else {
+		err = self.send(conn, "stats", args)
+	}
+	if err != nil {
+		return
+	}
+	for {
+		line, err := self.readLine(conn)
+		if err != nil {
+			return err
+		}
+		if line == _TEXT_END {
+			return nil
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 || fields[0] != _TEXT_STAT {
+			return self.reply(conn, line)
+		}
+		if len(fields) == 2 {
+			fields = append(fields, "")
+		}
+		fn(fields[1], fields[2])
+	}
+}
+
+func (self textProtocol) version(conn *nativeConn) (version string, err error) {
+	if err = self.send(conn, "version"); err != nil {
+		return
+	}
+	line, err := self.readLine(conn)
+	if err != nil {
+		return
+	}
+	version, ok := strings.CutPrefix(line, _TEXT_VERSION+" ")
+	if !ok {
+		return "", self.reply(conn, line)
+	}
+	return
+}
@@ -0,0 +1,342 @@
+package gomc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	_MAX_PREFIX_SIZE = 127
+)
+
+var (
+	ErrInvalidOptions = errors.New("Invalid options")
+)
+
+// Options gathers the settings of a client so that all of them are in place
+// before the first connection is made. A MaxSize above 1 builds a pool.
+// Behaviors go into the configuration string of the client when it has an
+// option for them, the others are set afterwards. Both come in a fixed order:
+// SUPPORT_CAS, KETAMA, KETAMA_WEIGHTED, DISTRIBUTION, HASH, KETAMA_HASH and
+// BINARY_PROTOCOL first, then the others. BEHAVIOR_KETAMA and
+// BEHAVIOR_KETAMA_WEIGHTED can not be set along with a hash or distribution
+// the configuration string has an option for.
+type Options struct {
+	Servers  []string
+	InitSize int
+	MaxSize  int
+	Encoding EncodingType
+
+	Behaviors      map[BehaviorType]uint64
+	ConnectTimeout time.Duration
+	PollTimeout    time.Duration
+	RetryTimeout   time.Duration
+	Hash           HashType
+	Distribution   DistributionType
+	BinaryProtocol bool
+
+	// CheckoutTimeout only applies to pools, see Pool.SetCheckoutTimeout.
+	CheckoutTimeout time.Duration
+
+	Prefix  string
+	Weights map[string]uint32
+
+	// Backend selects the implementation, see BackendType.
+	Backend BackendType
+}
+
+func invalidOptions(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidOptions, fmt.Sprintf(format, args...))
+}
+
+func (self *Options) backend() BackendType {
+	if self.Backend == BACKEND_DEFAULT {
+		return defaultBackend
+	}
+	return self.Backend
+}
+
+func (self *Options) pooled() bool {
+	return self.MaxSize > 1
+}
+
+func (self *Options) servers() []serverConfig {
+	servers := parseServers(self.Servers)
+	for i := range servers {
+		if weight, ok := self.Weights[servers[i].name()]; ok {
+			servers[i].weight = weight
+		} else if weight, ok := self.Weights[self.Servers[i]]; ok {
+			servers[i].weight = weight
+		}
+	}
+	return servers
+}
+
+func (self *Options) behaviors() map[BehaviorType]uint64 {
+	behaviors := make(map[BehaviorType]uint64, len(self.Behaviors)+6)
+	behaviors[BEHAVIOR_SUPPORT_CAS] = 1
+	for behavior, value := range self.Behaviors {
+		behaviors[behavior] = value
+	}
+	if self.ConnectTimeout > 0 {
+		behaviors[BEHAVIOR_CONNECT_TIMEOUT] = uint64(self.ConnectTimeout / time.Millisecond)
+	}
+	if self.PollTimeout > 0 {
+		behaviors[BEHAVIOR_POLL_TIMEOUT] = uint64(self.PollTimeout / time.Millisecond)
+	}
+	if self.RetryTimeout > 0 {
+		behaviors[BEHAVIOR_RETRY_TIMEOUT] = uint64(self.RetryTimeout / time.Second)
+	}
+	if self.Hash != HASH_DEFAULT {
+		behaviors[BEHAVIOR_HASH] = uint64(self.Hash)
+	}
+	if self.Distribution != DISTRIBUTION_MODULA {
+		behaviors[BEHAVIOR_DISTRIBUTION] = uint64(self.Distribution)
+	}
+	if self.BinaryProtocol {
+		behaviors[BEHAVIOR_BINARY_PROTOCOL] = 1
+	}
+	return behaviors
+}
+
+// config returns the configuration string options, those of the behaviors
+// the config language has an option for included, in the order of
+// orderBehaviors.
+func (self *Options) config(servers []serverConfig) (options []string) {
+	if self.pooled() {
+		initSize := self.InitSize
+		if initSize == 0 {
+			initSize = 1
+		}
+		options = poolOptions(servers, initSize, self.MaxSize)
+	} else {
+		options = clientOptions(servers)
+	}
+	configured, _ := self.splitBehaviors()
+	for _, option := range configured {
+		options = append(options, option.String())
+	}
+	if self.Prefix != "" {
+		options = append(options, ConfigOption{Name: "NAMESPACE", Value: self.Prefix}.String())
+	}
+	return
+}
+
+// splitBehaviors returns the config options setting the behaviors, and the
+// behaviors left to SetBehavior, both in the order of orderBehaviors.
+func (self *Options) splitBehaviors() (options []ConfigOption, rest []BehaviorType) {
+	behaviors := self.behaviors()
+	for _, behavior := range orderBehaviors(behaviors) {
+		if option, ok := behaviorOption(behavior, behaviors[behavior]); ok {
+			options = append(options, option)
+		} else {
+			rest = append(rest, behavior)
+		}
+	}
+	return
+}
+
+// behaviorOption returns the config option setting the behavior to value, if
+// any. A flag only has one to turn the behavior on.
+func behaviorOption(behavior BehaviorType, value uint64) (option ConfigOption, ok bool) {
+	switch behavior {
+	case BEHAVIOR_HASH, BEHAVIOR_KETAMA_HASH:
+		option.Name = "HASH"
+		if behavior == BEHAVIOR_KETAMA_HASH {
+			option.Name = "KETAMA-HASH"
+		}
+		for name, hash := range configHashes {
+			if uint64(hash) == value {
+				option.Value, ok = name, true
+			}
+		}
+		return
+	case BEHAVIOR_DISTRIBUTION:
+		option.Name = "DISTRIBUTION"
+		for name, distribution := range configDistributions {
+			if uint64(distribution) == value {
+				option.Value, ok = name, true
+			}
+		}
+		return
+	}
+
+	for name, configured := range configBehaviors {
+		if configured != behavior {
+			continue
+		}
+		if configKinds[name] == _CONFIG_KIND_NUMBER {
+			return ConfigOption{Name: name, Value: strconv.FormatUint(value, _NUMERIC_BASE)}, true
+		}
+		return ConfigOption{Name: name}, value != 0
+	}
+	return
+}
+
+func (self *Options) validate() error {
+	if len(self.Servers) == 0 {
+		return invalidOptions("no server")
+	}
+	if self.Backend < BACKEND_DEFAULT || self.Backend > BACKEND_NATIVE {
+		return invalidOptions("unknown backend %d", self.Backend)
+	}
+	if self.InitSize < 0 || self.MaxSize < 0 {
+		return invalidOptions("negative pool size")
+	}
+	if self.pooled() && self.InitSize > self.MaxSize {
+		return invalidOptions("init size %d exceeds max size %d", self.InitSize, self.MaxSize)
+	}
+	if !self.pooled() && (self.InitSize > 1 || self.CheckoutTimeout != 0) {
+		return invalidOptions("pool settings without a max size above 1")
+	}
+	if _, ok := lookupCodec(self.Encoding); !ok {
+		return invalidOptions("unsupported encoding %d", self.Encoding)
+	}
+	if self.Hash < HASH_DEFAULT || self.Hash >= HASH_CUSTOM {
+		return invalidOptions("unsupported hash %d", self.Hash)
+	}
+	if self.Distribution < DISTRIBUTION_MODULA || self.Distribution >= DISTRIBUTION_CONSISTENT_MAX {
+		return invalidOptions("unsupported distribution %d", self.Distribution)
+	}
+	if len(self.Prefix) > _MAX_PREFIX_SIZE {
+		return invalidOptions("prefix longer than %d bytes", _MAX_PREFIX_SIZE)
+	}
+	if strings.IndexFunc(self.Prefix, unicode.IsSpace) >= 0 {
+		return invalidOptions("prefix with whitespace")
+	}
+
+	fields := map[BehaviorType]bool{
+		BEHAVIOR_CONNECT_TIMEOUT: self.ConnectTimeout > 0,
+		BEHAVIOR_POLL_TIMEOUT:    self.PollTimeout > 0,
+		BEHAVIOR_RETRY_TIMEOUT:   self.RetryTimeout > 0,
+		BEHAVIOR_HASH:            self.Hash != HASH_DEFAULT,
+		BEHAVIOR_DISTRIBUTION:    self.Distribution != DISTRIBUTION_MODULA,
+		BEHAVIOR_BINARY_PROTOCOL: self.BinaryProtocol,
+	}
+	for behavior := range self.Behaviors {
+		if behavior < 0 || behavior >= BEHAVIOR_MAX {
+			return invalidOptions("unknown behavior %d", behavior)
+		}
+		if fields[behavior] {
+			return invalidOptions("behavior %d set twice", behavior)
+		}
+	}
+	if self.BinaryProtocol && self.Behaviors[BEHAVIOR_USE_UDP] != 0 {
+		return invalidOptions("binary protocol over UDP")
+	}
+	// Applied after the configuration string, they would reset its hash and
+	// distribution.
+	options, rest := self.splitBehaviors()
+	for _, behavior := range rest {
+		if behavior != BEHAVIOR_KETAMA && behavior != BEHAVIOR_KETAMA_WEIGHTED {
+			continue
+		}
+		for _, option := range options {
+			if option.Name == "HASH" || option.Name == "KETAMA-HASH" || option.Name == "DISTRIBUTION" {
+				return invalidOptions("behavior %d resets %s", behavior, option.Name)
+			}
+		}
+	}
+
+	servers := self.servers()
+	for server, weight := range self.Weights {
+		found := false
+		for i := range servers {
+			found = found || servers[i].name() == server || self.Servers[i] == server
+		}
+		if !found {
+			return invalidOptions("weight for unknown server %s", server)
+		}
+		if weight == 0 {
+			return invalidOptions("zero weight for server %s", server)
+		}
+	}
+	return nil
+}
+
+// Behaviors applied before the others, in this order. Some behaviors of
+// libmemcached change others: BEHAVIOR_KETAMA and BEHAVIOR_KETAMA_WEIGHTED
+// reset the distribution and the hash, which come next so that the ones asked
+// for win, and BEHAVIOR_BINARY_PROTOCOL needs BEHAVIOR_SUPPORT_CAS first.
+var behaviorOrder = []BehaviorType{
+	BEHAVIOR_SUPPORT_CAS,
+	BEHAVIOR_KETAMA,
+	BEHAVIOR_KETAMA_WEIGHTED,
+	BEHAVIOR_DISTRIBUTION,
+	BEHAVIOR_HASH,
+	BEHAVIOR_KETAMA_HASH,
+	BEHAVIOR_BINARY_PROTOCOL,
+}
+
+// orderBehaviors returns the behaviors to apply, those of behaviorOrder first
+// and the others by increasing value.
+func orderBehaviors(behaviors map[BehaviorType]uint64) []BehaviorType {
+	rank := func(behavior BehaviorType) int {
+		for i, ordered := range behaviorOrder {
+			if behavior == ordered {
+				return i
+			}
+		}
+		return len(behaviorOrder) + int(behavior)
+	}
+	ordered := make([]BehaviorType, 0, len(behaviors))
+	for behavior := range behaviors {
+		ordered = append(ordered, behavior)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return rank(ordered[i]) < rank(ordered[j])
+	})
+	return ordered
+}
+
+// apply sets what the configuration string can not: the behaviors it has no
+// option for and the checkout timeout.
+func (self *Options) apply(client Client) (err error) {
+	behaviors := self.behaviors()
+	_, rest := self.splitBehaviors()
+	for _, behavior := range rest {
+		if err = client.SetBehavior(behavior, behaviors[behavior]); err != nil {
+			return
+		}
+	}
+	if pool, ok := client.(Pool); ok {
+		pool.SetCheckoutTimeout(self.CheckoutTimeout)
+	}
+	return
+}
+
+// NewClientWithOptions validates options up front, then builds the client
+// and applies every setting before returning it.
+func NewClientWithOptions(options Options) (self Client, err error) {
+	if err = options.validate(); err != nil {
+		return
+	}
+
+	servers := options.servers()
+	config := join(options.config(servers))
+	self, err = newBackend(options.Backend, config, servers, options.pooled(), options.MaxSize, options.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	// The native backend gets the configuration string as behaviors.
+	if options.backend() == BACKEND_NATIVE {
+		var parsed *Config
+		if parsed, err = ParseConfig(config); err == nil {
+			err = parsed.apply(self)
+		}
+	}
+	if err == nil {
+		err = options.apply(self)
+	}
+	if err != nil {
+		self.Close()
+		return nil, err
+	}
+	return
+}
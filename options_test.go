@@ -0,0 +1,90 @@
+package gomc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	invalid := []Options{
+		{},
+		{Servers: testHosts, InitSize: 4, MaxSize: 2},
+		{Servers: testHosts, CheckoutTimeout: time.Second},
+		{Servers: testHosts, Encoding: EncodingType(42)},
+		{Servers: testHosts, Hash: HASH_CUSTOM},
+		{Servers: testHosts, Distribution: DISTRIBUTION_CONSISTENT_MAX},
+		{Servers: testHosts, Prefix: randomStr(_MAX_PREFIX_SIZE + 1)},
+		{Servers: testHosts, Hash: HASH_MD5, Behaviors: map[BehaviorType]uint64{BEHAVIOR_HASH: uint64(HASH_CRC)}},
+		{Servers: testHosts, Weights: map[string]uint32{"localhost:11219": 2}},
+		{Servers: testHosts, Weights: map[string]uint32{"localhost:11211": 0}},
+		{Servers: testHosts, Hash: HASH_MD5, Behaviors: map[BehaviorType]uint64{BEHAVIOR_KETAMA: 1}},
+	}
+	for _, options := range invalid {
+		if err := options.validate(); !errors.Is(err, ErrInvalidOptions) {
+			t.Error("Error validate:", options, err)
+		}
+	}
+
+	valid := Options{
+		Servers:        testHosts,
+		InitSize:       2,
+		MaxSize:        4,
+		Encoding:       ENCODING_JSON,
+		Hash:           HASH_MD5,
+		Distribution:   DISTRIBUTION_CONSISTENT_KETAMA,
+		BinaryProtocol: true,
+		Weights:        map[string]uint32{"localhost:11211": 3},
+	}
+	if err := valid.validate(); err != nil {
+		t.Error("Fail to validate:", err)
+	}
+}
+
+func TestOptionsConfig(t *testing.T) {
+	options := Options{
+		Servers:        []string{"localhost:11211", "/tmp/test-gomc-1.sock"},
+		InitSize:       2,
+		MaxSize:        4,
+		Prefix:         "app:",
+		Weights:        map[string]uint32{"localhost:11211": 3},
+		PollTimeout:    2 * time.Second,
+		RetryTimeout:   3 * time.Second,
+		Hash:           HASH_MD5,
+		Distribution:   DISTRIBUTION_CONSISTENT,
+		BinaryProtocol: true,
+	}
+	expect := "--SERVER=localhost:11211/?3 --SOCKET=/tmp/test-gomc-1.sock --POOL-MIN=2 --POOL-MAX=4 " +
+		"--SUPPORT-CAS --DISTRIBUTION=CONSISTENT --HASH=MD5 --BINARY-PROTOCOL --POLL-TIMEOUT=2000 --RETRY-TIMEOUT=3 --NAMESPACE=app:"
+	if config := join(options.config(options.servers())); config != expect {
+		t.Error("Error config:", config, ", expect:", expect)
+	}
+	if _, err := ParseConfig(expect); err != nil {
+		t.Error("Fail to parse config:", err)
+	}
+}
+
+func TestOptionsBehaviorOrder(t *testing.T) {
+	options := Options{
+		Behaviors:      map[BehaviorType]uint64{BEHAVIOR_KETAMA: 1, BEHAVIOR_TCP_NODELAY: 1, BEHAVIOR_NOREPLY: 0},
+		PollTimeout:    time.Second,
+		Distribution:   DISTRIBUTION_CONSISTENT_KETAMA,
+		BinaryProtocol: true,
+	}
+	expect := []string{"SUPPORT-CAS", "BINARY-PROTOCOL", "TCP-NODELAY", "POLL-TIMEOUT"}
+	expectRest := []BehaviorType{BEHAVIOR_KETAMA, BEHAVIOR_DISTRIBUTION, BEHAVIOR_NOREPLY}
+	for i := 0; i < 10; i++ {
+		configured, rest := options.splitBehaviors()
+		names := make([]string, len(configured))
+		for j, option := range configured {
+			names[j] = option.Name
+		}
+		if !reflect.DeepEqual(names, expect) {
+			t.Fatal("Error config order:", names, ", expect:", expect)
+		}
+		if !reflect.DeepEqual(rest, expectRest) {
+			t.Fatal("Error behavior order:", rest, ", expect:", expectRest)
+		}
+	}
+}
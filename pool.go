@@ -1,3 +1,5 @@
+//go:build cgo
+
 package gomc
 
 /*
@@ -6,45 +8,97 @@ package gomc
 #include <libmemcached/util.h>
 #include <stdlib.h>
 #include <stdint.h>
+
+// The generation of the server list a pooled connection was synced to is kept
+// in its user data, which clones of the pool master start with as NULL.
+static void gomc_set_generation(memcached_st *mc, uint64_t generation) {
+	memcached_set_user_data(mc, (void *)(uintptr_t)generation);
+}
+
+static uint64_t gomc_generation(const memcached_st *mc) {
+	return (uint64_t)(uintptr_t)memcached_get_user_data(mc);
+}
 */
 import "C"
 
 import (
-	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
 
+const (
+	// Wait of each fetch from the pool making up an unbounded wait.
+	_POOL_WAIT_STEP = time.Minute
+)
+
 type memcachedPool struct {
 	pool     *C.memcached_pool_st
 	encoding EncodingType
+	maxSize  int
+
+	checkoutTimeout int64
+	inUse           int64
+	waits           uint64
+	timeouts        uint64
+
+	lock       sync.Mutex
+	update     sync.Mutex
+	servers    []serverConfig
+	namespace  string
+	generation uint64
+	pipeline   *pipeline
 }
 
 func newPool(servers []string, initSize, maxSize int, encoding EncodingType) (self *memcachedPool, err error) {
-	config := poolConfig(servers, initSize, maxSize)
+	configs := parseServers(servers)
+	return newPoolWithConfig(poolConfig(configs, initSize, maxSize), configs, maxSize, encoding)
+}
+
+func newPoolWithConfig(config string, servers []serverConfig, maxSize int, encoding EncodingType) (self *memcachedPool, err error) {
 	cs_config := C.CString(config)
 	defer C.free(unsafe.Pointer(cs_config))
 
 	self = new(memcachedPool)
 	self.pool = C.memcached_pool(cs_config, C.size_t(len(config)))
 	if self.pool == nil {
-		err = self.checkError(
-			C.libmemcached_check_configuration(
-				cs_config, C.size_t(len(config)), nil, 0))
+		if err = checkConfiguration(config); err == nil {
+			err = newError(nil, C.memcached_return_t(MEMORY_ALLOCATION_FAILURE), "")
+		}
 		return
 	}
 	self.encoding = encoding
+	self.maxSize = maxSize
+	self.servers = servers
+	self.pipeline = newPipeline(maxSize)
+	if err = self.SetBehavior(BEHAVIOR_SUPPORT_CAS, 1); err != nil {
+		return
+	}
+	self.namespace, err = self.readNamespace()
 	return
 }
 
-func (self *memcachedPool) checkError(returnCode C.memcached_return_t) error {
-	if C.memcached_failed(returnCode) {
-		return errors.New(C.GoString(C.memcached_strerror(nil, returnCode)))
+// readNamespace reads the namespace the pool was configured with from one of
+// its connections, all of them being cloned from the same master.
+func (self *memcachedPool) readNamespace() (namespace string, err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
 	}
-	return nil
+
+	return conn.readNamespace(), nil
 }
 
-func (self *memcachedPool) SetBehavior(behavior BehaviorType, value uint64) error {
+func (self *memcachedPool) checkError(returnCode C.memcached_return_t) error {
+	return newError(nil, returnCode, "")
+}
+
+// Connections are cloned again from the pool master after a behavior change,
+// which drops any server list change, so they all have to be synced again.
+// The clones starting at generation 0, they are synced on their next fetch.
+func (self *memcachedPool) SetBehavior(behavior BehaviorType, value uint64) (err error) {
 	return self.checkError(
 		C.memcached_pool_behavior_set(
 			self.pool, C.memcached_behavior_t(behavior), C.uint64_t(value)))
@@ -57,20 +111,172 @@ func (self *memcachedPool) GetBehavior(behavior BehaviorType) (value uint64, err
 	return
 }
 
+// SetCheckoutTimeout bounds the wait for a free connection, after which an
+// exhausted pool is reported as ErrPoolExhausted. Zero, the default, and
+// negative timeouts wait for as long as it takes; see NoWait for an operation
+// that must not wait at all.
+func (self *memcachedPool) SetCheckoutTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&self.checkoutTimeout, int64(timeout))
+}
+
+func (self *memcachedPool) PoolStats() PoolStats {
+	return PoolStats{
+		MaxSize:  self.maxSize,
+		InUse:    atomic.LoadInt64(&self.inUse),
+		Waits:    atomic.LoadUint64(&self.waits),
+		Timeouts: atomic.LoadUint64(&self.timeouts),
+	}
+}
+
+// relativeTimespec converts a deadline into the relative timeout expected by
+// memcached_pool_fetch, which adds tv_sec to time(NULL) and takes tv_nsec as
+// is. Deriving both fields from the deadline keeps sub-second precision.
+func relativeTimespec(deadline time.Time) C.struct_timespec {
+	return C.struct_timespec{
+		tv_sec:  C.time_t(deadline.Unix() - time.Now().Unix()),
+		tv_nsec: C.long(deadline.Nanosecond()),
+	}
+}
+
 func (self *memcachedPool) fetchConnection() (conn *memcached, err error) {
+	return self.fetch(time.Time{}, true)
+}
+
+// fetch waits for a free connection until the earliest of deadline, if not
+// zero, and the checkout timeout, or without end when neither is set. The
+// pool is tried at once first, a failure counting as a wait. As libmemcached
+// fails at once on a zero or NULL timeout, an unbounded wait is made of waits
+// of _POOL_WAIT_STEP. Unless wait, the pool is tried only once.
+func (self *memcachedPool) fetch(deadline time.Time, wait bool) (conn *memcached, err error) {
+	if timeout := time.Duration(atomic.LoadInt64(&self.checkoutTimeout)); timeout > 0 {
+		limit := time.Now().Add(timeout)
+		if deadline.IsZero() || limit.Before(deadline) {
+			deadline = limit
+		}
+	}
+
+	conn = &memcached{encoding: self.encoding, pipeline: self.pipeline}
 	ret := new(C.memcached_return_t)
-	conn = &memcached{
-		mc:       C.memcached_pool_fetch(self.pool, nil, ret),
-		encoding: self.encoding,
+	for first := true; ; first = false {
+		var relative C.struct_timespec
+		if wait && !first {
+			step := time.Now().Add(_POOL_WAIT_STEP)
+			if !deadline.IsZero() && deadline.Before(step) {
+				step = deadline
+			}
+			relative = relativeTimespec(step)
+		}
+		if conn.mc = C.memcached_pool_fetch(self.pool, &relative, ret); conn.mc != nil {
+			break
+		}
+		if code := ReturnType(*ret); code != TIMEOUT && code != NOTFOUND {
+			return conn, self.checkError(*ret)
+		}
+		if first {
+			atomic.AddUint64(&self.waits, 1)
+		}
+		if !wait || !deadline.IsZero() && !time.Now().Before(deadline) {
+			atomic.AddUint64(&self.timeouts, 1)
+			return conn, ErrPoolExhausted
+		}
+	}
+	atomic.AddInt64(&self.inUse, 1)
+	err = self.syncConnection(conn)
+	return
+}
+
+// syncConnection applies the server list and namespace changes made through
+// the pool to a pooled connection that has not seen them yet.
+func (self *memcachedPool) syncConnection(conn *memcached) (err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	conn.servers = self.servers
+	conn.namespace = self.namespace
+	if uint64(C.gomc_generation(conn.mc)) == self.generation {
+		return
+	}
+	if err = conn.applyNamespace(self.servers, self.namespace); err == nil {
+		C.gomc_set_generation(conn.mc, C.uint64_t(self.generation))
 	}
-	err = self.checkError(*ret)
+	return
+}
+
+func (self *memcachedPool) updateConnections(servers []serverConfig, namespace string) (err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if err = conn.applyNamespace(servers, namespace); err != nil {
+		conn.applyNamespace(self.servers, self.namespace)
+		return
+	}
+	self.servers = servers
+	self.namespace = namespace
+	self.generation++
+	C.gomc_set_generation(conn.mc, C.uint64_t(self.generation))
 	return
 }
 
 func (self *memcachedPool) releaseConnection(conn *memcached) error {
+	if conn.mc == nil {
+		return nil
+	}
+	atomic.AddInt64(&self.inUse, -1)
 	return self.checkError(C.memcached_pool_release(self.pool, conn.mc))
 }
 
+func (self *memcachedPool) AddServer(host string, port int, weight uint32) error {
+	self.update.Lock()
+	defer self.update.Unlock()
+
+	server := newServerConfig(host, port, weight)
+	if findServer(self.servers, server.name()) >= 0 {
+		return ErrServerExists
+	}
+	return self.updateConnections(append(self.servers[:len(self.servers):len(self.servers)], server), self.namespace)
+}
+
+func (self *memcachedPool) RemoveServer(host string, port int) error {
+	self.update.Lock()
+	defer self.update.Unlock()
+
+	i := findServer(self.servers, newServerConfig(host, port, 0).name())
+	if i < 0 {
+		return ErrUnknownServer
+	}
+	servers := make([]serverConfig, 0, len(self.servers)-1)
+	return self.updateConnections(append(append(servers, self.servers[:i]...), self.servers[i+1:]...), self.namespace)
+}
+
+func (self *memcachedPool) SetNamespace(namespace string) error {
+	self.update.Lock()
+	defer self.update.Unlock()
+
+	return self.updateConnections(self.servers, namespace)
+}
+
+func (self *memcachedPool) Namespace() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.namespace
+}
+
+func (self *memcachedPool) Servers() (servers []ServerInfo, err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.Servers()
+}
+
 func (self *memcachedPool) GenerateHash(key string) (hash uint32, err error) {
 	conn, err := self.fetchConnection()
 	defer self.releaseConnection(conn)
@@ -82,6 +288,26 @@ func (self *memcachedPool) GenerateHash(key string) (hash uint32, err error) {
 	return
 }
 
+func (self *memcachedPool) ServerForKey(key string) (info ServerInfo, err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.ServerForKey(key)
+}
+
+func (self *memcachedPool) ServersForKeys(keys []string) (groups map[string][]string, err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.ServersForKeys(keys)
+}
+
 func (self *memcachedPool) Increment(key string, offset uint32) (value uint64, err error) {
 	conn, err := self.fetchConnection()
 	defer self.releaseConnection(conn)
@@ -102,6 +328,26 @@ func (self *memcachedPool) Decrement(key string, offset uint32) (value uint64, e
 	return conn.Decrement(key, offset)
 }
 
+func (self *memcachedPool) IncrementWithInitial(key string, offset, initial uint64, expiration time.Duration) (value uint64, err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.IncrementWithInitial(key, offset, initial, expiration)
+}
+
+func (self *memcachedPool) DecrementWithInitial(key string, offset, initial uint64, expiration time.Duration) (value uint64, err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.DecrementWithInitial(key, offset, initial, expiration)
+}
+
 func (self *memcachedPool) Delete(key string, expiration time.Duration) (err error) {
 	conn, err := self.fetchConnection()
 	defer self.releaseConnection(conn)
@@ -122,6 +368,26 @@ func (self *memcachedPool) Exist(key string) (err error) {
 	return conn.Exist(key)
 }
 
+func (self *memcachedPool) Touch(key string, expiration time.Duration) (err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.Touch(key, expiration)
+}
+
+func (self *memcachedPool) TouchMulti(keys []string, expiration time.Duration) (err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.TouchMulti(keys, expiration)
+}
+
 func (self *memcachedPool) Flush(expiration time.Duration) (err error) {
 	conn, err := self.fetchConnection()
 	defer self.releaseConnection(conn)
@@ -142,6 +408,16 @@ func (self *memcachedPool) Get(key string, value interface{}) (err error) {
 	return conn.Get(key, value)
 }
 
+func (self *memcachedPool) GetAndTouch(key string, value interface{}, expiration time.Duration) (err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.GetAndTouch(key, value, expiration)
+}
+
 func (self *memcachedPool) GetMulti(keys []string) (res Result, err error) {
 	conn, err := self.fetchConnection()
 	defer self.releaseConnection(conn)
@@ -152,6 +428,26 @@ func (self *memcachedPool) GetMulti(keys []string) (res Result, err error) {
 	return conn.GetMulti(keys)
 }
 
+func (self *memcachedPool) GetMultiFunc(keys []string, fn func(*Item) error) (err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.GetMultiFunc(keys, fn)
+}
+
+func (self *memcachedPool) GetWithCAS(key string, value interface{}) (cas uint64, err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.GetWithCAS(key, value)
+}
+
 func (self *memcachedPool) Add(key string, value interface{}, expiration time.Duration) (err error) {
 	conn, err := self.fetchConnection()
 	defer self.releaseConnection(conn)
@@ -182,6 +478,97 @@ func (self *memcachedPool) Set(key string, value interface{}, expiration time.Du
 	return conn.Set(key, value, expiration)
 }
 
+func (self *memcachedPool) Append(key string, value interface{}, expiration time.Duration) (err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.Append(key, value, expiration)
+}
+
+func (self *memcachedPool) Prepend(key string, value interface{}, expiration time.Duration) (err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.Prepend(key, value, expiration)
+}
+
+func (self *memcachedPool) CompareAndSwap(key string, value interface{}, cas uint64, expiration time.Duration) (err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.CompareAndSwap(key, value, cas, expiration)
+}
+
+func (self *memcachedPool) SetMulti(items map[string]interface{}, expiration time.Duration) (err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.SetMulti(items, expiration)
+}
+
+func (self *memcachedPool) AddMulti(items map[string]interface{}, expiration time.Duration) (err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.AddMulti(items, expiration)
+}
+
+func (self *memcachedPool) DeleteMulti(keys []string) (err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.DeleteMulti(keys)
+}
+
+func (self *memcachedPool) Stats(args string) (stats map[string]ServerStats, err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.Stats(args)
+}
+
+func (self *memcachedPool) Versions() (versions map[string]string, err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.Versions()
+}
+
+func (self *memcachedPool) Ping() (res map[string]PingResult, err error) {
+	conn, err := self.fetchConnection()
+	defer self.releaseConnection(conn)
+	if err != nil {
+		return
+	}
+
+	return conn.Ping()
+}
+
 func (self *memcachedPool) Close() {
 	C.memcached_pool_destroy(self.pool)
+	self.pipeline.Close()
 }
@@ -0,0 +1,160 @@
+//go:build cgo
+
+package gomc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ianoshen/gomc/gomctest"
+)
+
+func TestPoolServers(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	pool, err := newPool(testHosts[:1], 2, 2, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	if err = pool.AddServer("localhost", 11212, 1); err != nil {
+		t.Error("Fail to add server:", err)
+	}
+	if err = pool.RemoveServer("localhost", 11211); err != nil {
+		t.Error("Fail to remove server:", err)
+	}
+
+	// Check out every pooled connection at once so that each one is synced.
+	conns := make([]*memcached, 2)
+	for i := range conns {
+		if conns[i], err = pool.fetchConnection(); err != nil {
+			t.Error("Fail to fetch connection:", err)
+		}
+	}
+	for _, conn := range conns {
+		if servers, _ := conn.Servers(); len(servers) != 1 || servers[0].Port != 11212 {
+			t.Error("Error servers:", servers)
+		}
+		pool.releaseConnection(conn)
+	}
+}
+
+func TestPoolContext(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	pool, err := newPool(testHosts, 1, 1, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	conn, err := pool.fetchConnection()
+	if err != nil {
+		t.Error("Fail to fetch connection:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err = pool.SetContext(ctx, "test-key", "test-value", 0); err != context.DeadlineExceeded {
+		t.Error("Error set:", err, ", expect:", context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("Error wait for connection:", elapsed)
+	}
+
+	pool.releaseConnection(conn)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = pool.SetContext(ctx, "test-key", "test-value", 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+}
+
+func TestPoolCheckoutTimeout(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	pool, err := newPool(testHosts, 1, 1, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	conn, err := pool.fetchConnection()
+	if err != nil {
+		t.Error("Fail to fetch connection:", err)
+	}
+
+	if err = pool.SetContext(NoWait(context.Background()), "test-key", "test-value", 0); err != ErrPoolExhausted {
+		t.Error("Error set:", err, ", expect:", ErrPoolExhausted)
+	}
+
+	pool.SetCheckoutTimeout(100 * time.Millisecond)
+	start := time.Now()
+	if err = pool.Set("test-key", "test-value", 0); err != ErrPoolExhausted {
+		t.Error("Error set:", err, ", expect:", ErrPoolExhausted)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Error("Error checkout wait:", elapsed)
+	}
+
+	if stats := pool.PoolStats(); stats.InUse != 1 || stats.Waits != 2 || stats.Timeouts != 2 {
+		t.Error("Error pool stats:", stats)
+	}
+
+	// Without a checkout timeout, the wait lasts until a connection is free.
+	pool.SetCheckoutTimeout(0)
+	time.AfterFunc(200*time.Millisecond, func() {
+		pool.releaseConnection(conn)
+	})
+	if err = pool.Set("test-key", "test-value", 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	if stats := pool.PoolStats(); stats.InUse != 0 {
+		t.Error("Error in use:", stats.InUse, ", expect:", 0)
+	}
+}
+
+func TestPoolNamespace(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	pool, err := NewClientWithOptions(Options{
+		Servers: testHosts[:1],
+		MaxSize: 2,
+		Prefix:  "app:",
+	})
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+	if pool.Namespace() != "app:" {
+		t.Error("Error configured namespace:", pool.Namespace())
+	}
+	if err = pool.SetNamespace("svc:"); err != nil {
+		t.Error("Fail to set namespace:", err)
+	}
+	if err = pool.Set("foo", "bar", 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+
+	mc, err := newTestClient(testHosts[:1], ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+	var value string
+	if err = mc.Get("svc:foo", &value); err != nil || value != "bar" {
+		t.Error("Error prefixed value:", value, err)
+	}
+
+	// Every pooled connection uses the new namespace once synced.
+	conns := make([]*memcached, 2)
+	for i := range conns {
+		if conns[i], err = pool.(*memcachedPool).fetchConnection(); err != nil {
+			t.Error("Fail to fetch connection:", err)
+		}
+	}
+	for _, conn := range conns {
+		if conn.readNamespace() != "svc:" {
+			t.Error("Error connection namespace:", conn.readNamespace())
+		}
+		pool.(*memcachedPool).releaseConnection(conn)
+	}
+}
@@ -1,15 +1,17 @@
 package gomc
 
 import (
+	"errors"
 	"testing"
 	"time"
+
+	"github.com/ianoshen/gomc/gomctest"
 )
 
 func TestPoolBehavior(t *testing.T) {
-	cmds := start(testHosts)
-	defer stop(cmds)
+	gomctest.StartAt(t, testHosts...)
 
-	pool, err := newPool(testHosts, 1, 2, ENCODING_DEFAULT)
+	pool, err := newTestPool(testHosts, 1, 2, ENCODING_DEFAULT)
 	if err != nil {
 		t.Error("Fail to new client:", err)
 	}
@@ -24,8 +26,7 @@ func TestPoolBehavior(t *testing.T) {
 }
 
 func TestPoolSetGet(t *testing.T) {
-	cmds := start(testHosts)
-	defer stop(cmds)
+	gomctest.StartAt(t, testHosts...)
 
 	var (
 		testKey   = "test-key"
@@ -33,7 +34,7 @@ func TestPoolSetGet(t *testing.T) {
 		testExpr  = time.Second
 	)
 
-	pool, err := newPool(testHosts, 1, 2, ENCODING_DEFAULT)
+	pool, err := newTestPool(testHosts, 1, 2, ENCODING_DEFAULT)
 	if err != nil {
 		t.Error("Fail to new client:", err)
 	}
@@ -56,13 +57,109 @@ func TestPoolSetGet(t *testing.T) {
 	}
 }
 
+func TestPoolIncrementWithInitial(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	testKey := "test-counter"
+	pool, err := newTestPool(testHosts, 1, 2, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	if err = pool.SetBehavior(BEHAVIOR_BINARY_PROTOCOL, 1); err != nil {
+		t.Error("Fail to set behavior:", err)
+	}
+
+	for i := uint64(0); i < 3; i++ {
+		if value, err := pool.IncrementWithInitial(testKey, 2, 1, 0); err != nil {
+			t.Error("Fail to increment:", err)
+		} else if value != 1+2*i {
+			t.Error("Error increment:", value, ", expect:", 1+2*i)
+		}
+	}
+}
+
+func TestPoolCompareAndSwap(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	testKey := "test-key"
+	testValue := "test-value"
+	pool, err := newTestPool(testHosts, 1, 2, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+
+	if err = pool.Set(testKey, testValue, 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+
+	var val string
+	cas, err := pool.GetWithCAS(testKey, &val)
+	if err != nil {
+		t.Error("Fail to get with cas:", err)
+	}
+
+	if err = pool.CompareAndSwap(testKey, "new-value", cas, 0); err != nil {
+		t.Error("Fail to compare and swap:", err)
+	}
+
+	if err = pool.CompareAndSwap(testKey, "stale-value", cas, 0); !errors.Is(err, ErrCASConflict) {
+		t.Error("Error compare and swap:", err, ", expect:", ErrCASConflict)
+	}
+}
+
+func TestNewClientWithOptions(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	cli, err := NewClientWithOptions(Options{
+		Servers:         testHosts,
+		InitSize:        1,
+		MaxSize:         2,
+		Distribution:    DISTRIBUTION_CONSISTENT_KETAMA,
+		CheckoutTimeout: time.Second,
+		Backend:         testBackend(),
+	})
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+	defer cli.Close()
+
+	if _, ok := cli.(Pool); !ok {
+		t.Error("Error client type, expect a pool")
+	}
+	if behavior, _ := cli.GetBehavior(BEHAVIOR_DISTRIBUTION); DistributionType(behavior) != DISTRIBUTION_CONSISTENT_KETAMA {
+		t.Error("Error behavior:", behavior, ", expect:", DISTRIBUTION_CONSISTENT_KETAMA)
+	}
+	if err = cli.Set("test-key", "test-value", 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+}
+
+func TestPoolMulti(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	pool, err := newTestPool(testHosts, 1, 2, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+	if err = pool.SetMulti(map[string]interface{}{"foo": 1, "bar": 2}, 0); err != nil {
+		t.Error("Fail to set multi:", err)
+	}
+	var errs MultiError
+	if err = pool.AddMulti(map[string]interface{}{"foo": 3}, 0); !errors.As(err, &errs) || !errors.Is(errs["foo"], ErrNotStored) {
+		t.Error("Error add multi:", err)
+	}
+	if err = pool.DeleteMulti([]string{"foo", "bar"}); err != nil {
+		t.Error("Fail to delete multi:", err)
+	}
+}
+
 func BenchmarkPoolGet(b *testing.B) {
 	b.StopTimer()
 
-	cmds := start(testHosts)
-	defer stop(cmds)
+	gomctest.StartAt(b, testHosts...)
 
-	pool, _ := newPool(testHosts, 1, 2, ENCODING_DEFAULT)
+	pool, _ := newTestPool(testHosts, 1, 2, ENCODING_DEFAULT)
 	testKey := "test-key"
 	testValue := "test-value"
 	restoreValue := new(string)
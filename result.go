@@ -1,42 +1,114 @@
 package gomc
 
-import (
-	"fmt"
-)
-
-/*
-#cgo LDFLAGS: -L/usr/lib -lmemcached -lmemcachedutil
-#include <libmemcached/memcached.h>
-#include <libmemcached/util.h>
-#include <stdlib.h>
-#include <stdint.h>
-*/
-import "C"
+// Item is a value as read from memcached, before decoding. An *Item given to
+// the storage commands or to Get skips encoding and decoding.
+type Item struct {
+	Key   string
+	Value []byte
+	Flags uint32
+	CAS   uint64
+}
 
-type row struct {
-	buffer []byte
-	flags  uint32
+func (self *Item) Decode(value interface{}) error {
+	return decode(self.Value, self.Flags, value)
 }
 
 type result struct {
-	rows map[string]*row
+	keys []string
+	rows map[string]*Item
+
+	// Only filled when some servers failed: the error of each failed server
+	// and the server of each requested key.
+	errors  map[string]error
+	servers map[string]string
+}
+
+func newResult(keys []string) *result {
+	return &result{keys: keys, rows: make(map[string]*Item, len(keys))}
+}
+
+func (self *result) set(item *Item) {
+	self.rows[item.Key] = item
 }
 
-func newResult(size int) *result {
-	return &result{rows: make(map[string]*row, size)}
+func (self *result) setServerError(key, server string, err error) {
+	if self.errors == nil {
+		self.errors = make(map[string]error)
+		self.servers = make(map[string]string)
+	}
+	self.servers[key] = server
+	if err != nil {
+		self.errors[server] = err
+	}
 }
 
-func (self *result) set(key string, buffer []byte, flags uint32) {
-	self.rows[key] = &row{
-		buffer: buffer,
-		flags:  flags,
+// missing tells why key has no result, a miss being told apart from a key
+// held by a server that failed.
+func (self *result) missing(key string) error {
+	if err, ok := self.errors[self.servers[key]]; ok {
+		return err
 	}
+	return &Error{Code: NOTFOUND, Message: "No result for key `" + key + "`"}
 }
 
 func (self *result) Get(key string, value interface{}) (err error) {
 	if row, ok := self.rows[key]; ok {
-		return decode(row.buffer, row.flags, value)
+		return row.Decode(value)
+	}
+	return self.missing(key)
+}
+
+func (self *result) CAS(key string) (cas uint64, err error) {
+	if row, ok := self.rows[key]; ok {
+		return row.CAS, nil
+	}
+	return 0, self.missing(key)
+}
+
+func (self *result) Item(key string) (item *Item, err error) {
+	if row, ok := self.rows[key]; ok {
+		return row, nil
+	}
+	return nil, self.missing(key)
+}
+
+func (self *result) Has(key string) bool {
+	_, ok := self.rows[key]
+	return ok
+}
+
+func (self *result) Len() int {
+	return len(self.rows)
+}
+
+func (self *result) filter(found bool) (keys []string) {
+	seen := make(map[string]bool, len(self.keys))
+	for _, key := range self.keys {
+		if !seen[key] && self.Has(key) == found {
+			keys = append(keys, key)
+		}
+		seen[key] = true
 	}
-	err = fmt.Errorf("No result for key `%s`", key)
 	return
 }
+
+// Keys lists the keys found, in the order they were requested.
+func (self *result) Keys() []string {
+	return self.filter(true)
+}
+
+// Missing lists the keys requested but not found, including those held by
+// a failed server.
+func (self *result) Missing() []string {
+	return self.filter(false)
+}
+
+// Errors holds the error of each server that failed during the multi-get,
+// keyed by server name.
+func (self *result) Errors() map[string]error {
+	errors := make(map[string]error, len(self.errors))
+	for server, err := range self.errors {
+		errors[server] = err
+	}
+	return errors
+}
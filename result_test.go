@@ -0,0 +1,56 @@
+package gomc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestResult(t *testing.T) {
+	res := newResult([]string{"foo", "bar", "baz", "foo", "qux"})
+	res.set(&Item{Key: "foo", Value: []byte("1"), Flags: encodingFlag(ENCODING_DEFAULT), CAS: 42})
+	res.set(&Item{Key: "baz", Value: []byte("3"), Flags: encodingFlag(ENCODING_DEFAULT)})
+
+	if res.Len() != 2 || !res.Has("foo") || res.Has("bar") {
+		t.Error("Error result size:", res.Len())
+	}
+	if keys := res.Keys(); !reflect.DeepEqual(keys, []string{"foo", "baz"}) {
+		t.Error("Error keys:", keys)
+	}
+	if keys := res.Missing(); !reflect.DeepEqual(keys, []string{"bar", "qux"}) {
+		t.Error("Error missing keys:", keys)
+	}
+
+	var value int
+	if err := res.Get("foo", &value); err != nil || value != 1 {
+		t.Error("Error value:", value, err)
+	}
+	if cas, err := res.CAS("foo"); err != nil || cas != 42 {
+		t.Error("Error cas:", cas, err)
+	}
+	if item, err := res.Item("baz"); err != nil || string(item.Value) != "3" {
+		t.Error("Error item:", item, err)
+	}
+	if err := res.Get("bar", &value); !errors.Is(err, ErrNotFound) {
+		t.Error("Error missing key:", err)
+	}
+	if _, err := res.CAS("bar"); !errors.Is(err, ErrNotFound) {
+		t.Error("Error missing cas:", err)
+	}
+	if len(res.Errors()) != 0 {
+		t.Error("Error server errors:", res.Errors())
+	}
+
+	failure := &Error{Code: CONNECTION_FAILURE, Server: "localhost:11212"}
+	res.setServerError("bar", "localhost:11212", failure)
+	res.setServerError("qux", "localhost:11211", nil)
+	if err := res.Get("bar", &value); !errors.Is(err, ErrConnection) {
+		t.Error("Error key of failed server:", err)
+	}
+	if err := res.Get("qux", &value); !errors.Is(err, ErrNotFound) {
+		t.Error("Error key of working server:", err)
+	}
+	if errs := res.Errors(); len(errs) != 1 || errs["localhost:11212"] != failure {
+		t.Error("Error server errors:", errs)
+	}
+}
@@ -0,0 +1,44 @@
+package gomc
+
+import (
+	"time"
+)
+
+type ServerState int
+
+const (
+	SERVER_STATE_ALIVE ServerState = iota
+	SERVER_STATE_FAILED
+)
+
+var (
+	ErrServerExists  = &Error{Code: INVALID_ARGUMENTS, Message: "Server is already configured"}
+	ErrUnknownServer = &Error{Code: INVALID_ARGUMENTS, Message: "Server is not configured"}
+)
+
+type ServerInfo struct {
+	Host       string
+	Port       int
+	Weight     uint32
+	Connection ConnectionType
+	State      ServerState
+	LastError  string
+}
+
+func (self ServerInfo) Name() string {
+	return newServerConfig(self.Host, self.Port, self.Weight).name()
+}
+
+type PingResult struct {
+	Latency time.Duration
+	Err     error
+}
+
+func findServer(servers []serverConfig, name string) int {
+	for i, server := range servers {
+		if server.name() == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,208 @@
+//go:build cgo
+
+package gomc
+
+/*
+#include <libmemcached/memcached.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+const (
+	_SERVER_TYPE_UDP    = "UDP"
+	_SERVER_TYPE_SOCKET = "SOCKET"
+
+	_UNKNOWN_VERSION = 255
+)
+
+func instanceConnection(instance C.memcached_server_instance_st) ConnectionType {
+	switch C.GoString(C.memcached_server_type(instance)) {
+	case _SERVER_TYPE_UDP:
+		return CONNECTION_UDP
+	case _SERVER_TYPE_SOCKET:
+		return CONNECTION_UNIX_SOCKET
+	}
+	return CONNECTION_TCP
+}
+
+func (self *memcached) eachServer(fn func(C.memcached_server_instance_st)) {
+	count := C.memcached_server_count(self.mc)
+	for i := C.uint32_t(0); i < count; i++ {
+		fn(C.memcached_server_instance_by_position(self.mc, i))
+	}
+}
+
+func (self *memcached) addServer(host string, port int, weight uint32, connection ConnectionType) error {
+	cs_host := C.CString(host)
+	defer C.free(unsafe.Pointer(cs_host))
+
+	switch connection {
+	case CONNECTION_UNIX_SOCKET:
+		return self.checkError(
+			C.memcached_server_add_unix_socket_with_weight(
+				self.mc, cs_host, C.uint32_t(weight)))
+	case CONNECTION_UDP:
+		return self.checkError(
+			C.memcached_server_add_udp_with_weight(
+				self.mc, cs_host, C.in_port_t(port), C.uint32_t(weight)))
+	}
+	return self.checkError(
+		C.memcached_server_add_with_weight(
+			self.mc, cs_host, C.in_port_t(port), C.uint32_t(weight)))
+}
+
+func (self *memcached) applyServers(servers []serverConfig) (err error) {
+	C.memcached_servers_reset(self.mc)
+	for _, server := range servers {
+		if err = self.addServer(server.host, server.port, server.weight, server.connection); err != nil {
+			return
+		}
+	}
+	self.servers = servers
+	return
+}
+
+func (self *memcached) AddServer(host string, port int, weight uint32) (err error) {
+	server := newServerConfig(host, port, weight)
+	if findServer(self.servers, server.name()) >= 0 {
+		return ErrServerExists
+	}
+	if err = self.addServer(server.host, server.port, server.weight, server.connection); err != nil {
+		return
+	}
+	self.servers = append(self.servers[:len(self.servers):len(self.servers)], server)
+	return
+}
+
+func (self *memcached) RemoveServer(host string, port int) (err error) {
+	i := findServer(self.servers, newServerConfig(host, port, 0).name())
+	if i < 0 {
+		return ErrUnknownServer
+	}
+	servers := make([]serverConfig, 0, len(self.servers)-1)
+	servers = append(append(servers, self.servers[:i]...), self.servers[i+1:]...)
+
+	previous := self.servers
+	if err = self.applyServers(servers); err != nil {
+		self.applyServers(previous)
+	}
+	return
+}
+
+func (self *memcached) serverInfo(instance C.memcached_server_instance_st) (info ServerInfo) {
+	info = ServerInfo{
+		Host:       C.GoString(C.memcached_server_name(instance)),
+		Port:       int(C.memcached_server_port(instance)),
+		Weight:     1,
+		Connection: instanceConnection(instance),
+		State:      SERVER_STATE_ALIVE,
+	}
+	if i := findServer(self.servers, serverName(instance)); i >= 0 {
+		info.Weight = self.servers[i].weight
+	}
+	if C.memcached_failed(C.memcached_server_error_return(instance)) {
+		info.State = SERVER_STATE_FAILED
+		info.LastError = C.GoString(C.memcached_server_error(instance))
+	}
+	return
+}
+
+func (self *memcached) Servers() (servers []ServerInfo, err error) {
+	self.eachServer(func(instance C.memcached_server_instance_st) {
+		servers = append(servers, self.serverInfo(instance))
+	})
+	return
+}
+
+func (self *memcached) ServerForKey(key string) (info ServerInfo, err error) {
+	cs_key, key_len := cString(key)
+	defer C.free(unsafe.Pointer(cs_key))
+
+	rc := new(C.memcached_return_t)
+	instance := C.memcached_server_by_key(self.mc, cs_key, key_len, rc)
+	if err = self.checkError(*rc); err != nil {
+		return
+	}
+	if instance == nil {
+		err = self.checkError(C.memcached_return_t(NO_SERVERS))
+		return
+	}
+	info = self.serverInfo(instance)
+	return
+}
+
+// ServersForKeys groups keys by the name of the server they are hashed to.
+func (self *memcached) ServersForKeys(keys []string) (groups map[string][]string, err error) {
+	groups = make(map[string][]string)
+	for _, key := range keys {
+		info, err := self.ServerForKey(key)
+		if err != nil {
+			return nil, err
+		}
+		groups[info.Name()] = append(groups[info.Name()], key)
+	}
+	return
+}
+
+func (self *memcached) Versions() (versions map[string]string, err error) {
+	err = self.checkError(C.memcached_version(self.mc))
+	versions = make(map[string]string)
+	self.eachServer(func(instance C.memcached_server_instance_st) {
+		major := C.memcached_server_major_version(instance)
+		if major == _UNKNOWN_VERSION {
+			return
+		}
+		versions[serverName(instance)] = fmt.Sprintf("%d.%d.%d",
+			major,
+			C.memcached_server_minor_version(instance),
+			C.memcached_server_micro_version(instance))
+	})
+	return
+}
+
+// ping sends a version request to a single server through a clone of the
+// client, so that timeouts and protocol behaviors are the configured ones.
+func (self *memcached) ping(instance C.memcached_server_instance_st) (res PingResult) {
+	probe := &memcached{
+		mc:       C.memcached_clone(nil, self.mc),
+		encoding: self.encoding,
+	}
+	if probe.mc == nil {
+		res.Err = newError(nil, C.memcached_return_t(MEMORY_ALLOCATION_FAILURE), "")
+		return
+	}
+	defer probe.Close()
+
+	C.memcached_servers_reset(probe.mc)
+	if res.Err = probe.addServer(
+		C.GoString(C.memcached_server_name(instance)),
+		int(C.memcached_server_port(instance)),
+		1, instanceConnection(instance)); res.Err != nil {
+		return
+	}
+
+	start := time.Now()
+	res.Err = probe.checkError(C.memcached_version(probe.mc))
+	res.Latency = time.Since(start)
+	return
+}
+
+// Ping probes every server one by one and reports each latency, err being
+// the first failure met so that it can be used as a readiness check.
+func (self *memcached) Ping() (res map[string]PingResult, err error) {
+	res = make(map[string]PingResult)
+	self.eachServer(func(instance C.memcached_server_instance_st) {
+		server := serverName(instance)
+		res[server] = self.ping(instance)
+		if res[server].Err != nil && err == nil {
+			err = res[server].Err
+		}
+	})
+	return
+}
@@ -0,0 +1,97 @@
+package gomc
+
+import (
+	"strconv"
+)
+
+type ServerStats struct {
+	Pid              uint64
+	Uptime           uint64
+	Time             uint64
+	Version          string
+	Threads          uint64
+	CurrConnections  uint64
+	TotalConnections uint64
+	CurrItems        uint64
+	TotalItems       uint64
+	Bytes            uint64
+	LimitMaxbytes    uint64
+	CmdGet           uint64
+	CmdSet           uint64
+	GetHits          uint64
+	GetMisses        uint64
+	Evictions        uint64
+	BytesRead        uint64
+	BytesWritten     uint64
+	Raw              map[string]string
+}
+
+func newServerStats() *ServerStats {
+	return &ServerStats{Raw: make(map[string]string)}
+}
+
+func (self *ServerStats) set(key, value string) {
+	self.Raw[key] = value
+
+	var field *uint64
+	switch key {
+	case "pid":
+		field = &self.Pid
+	case "uptime":
+		field = &self.Uptime
+	case "time":
+		field = &self.Time
+	case "version":
+		self.Version = value
+	case "threads":
+		field = &self.Threads
+	case "curr_connections":
+		field = &self.CurrConnections
+	case "total_connections":
+		field = &self.TotalConnections
+	case "curr_items":
+		field = &self.CurrItems
+	case "total_items":
+		field = &self.TotalItems
+	case "bytes":
+		field = &self.Bytes
+	case "limit_maxbytes":
+		field = &self.LimitMaxbytes
+	case "cmd_get":
+		field = &self.CmdGet
+	case "cmd_set":
+		field = &self.CmdSet
+	case "get_hits":
+		field = &self.GetHits
+	case "get_misses":
+		field = &self.GetMisses
+	case "evictions":
+		field = &self.Evictions
+	case "bytes_read":
+		field = &self.BytesRead
+	case "bytes_written":
+		field = &self.BytesWritten
+	}
+	if field != nil {
+		*field, _ = strconv.ParseUint(value, _NUMERIC_BASE, 64)
+	}
+}
+
+type statsCollector map[string]*ServerStats
+
+func (self statsCollector) add(server, key, value string) {
+	stats, ok := self[server]
+	if !ok {
+		stats = newServerStats()
+		self[server] = stats
+	}
+	stats.set(key, value)
+}
+
+func (self statsCollector) result() map[string]ServerStats {
+	res := make(map[string]ServerStats, len(self))
+	for server, stats := range self {
+		res[server] = *stats
+	}
+	return res
+}
@@ -0,0 +1,46 @@
+//go:build cgo
+
+package gomc
+
+/*
+#include <libmemcached/memcached.h>
+#include <stdlib.h>
+
+extern memcached_return_t gomcStatCallback(memcached_server_instance_st, char *, size_t, char *, size_t, void *);
+*/
+import "C"
+
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+//export gomcStatCallback
+func gomcStatCallback(instance C.memcached_server_instance_st, key *C.char, key_len C.size_t, value *C.char, value_len C.size_t, context unsafe.Pointer) C.memcached_return_t {
+	collector := (*(*cgo.Handle)(context)).Value().(statsCollector)
+	collector.add(
+		serverName(instance),
+		C.GoStringN(key, C.int(key_len)),
+		C.GoStringN(value, C.int(value_len)))
+	return C.memcached_return_t(SUCCESS)
+}
+
+// Stats runs `stats <args>` against every server, args being empty for the
+// general statistics or a group such as "slabs", "items" or "settings".
+func (self *memcached) Stats(args string) (stats map[string]ServerStats, err error) {
+	var cs_args *C.char
+	if args != "" {
+		cs_args = C.CString(args)
+		defer C.free(unsafe.Pointer(cs_args))
+	}
+
+	collector := make(statsCollector)
+	handle := cgo.NewHandle(collector)
+	defer handle.Delete()
+
+	err = self.checkError(
+		C.memcached_stat_execute(
+			self.mc, cs_args, C.memcached_stat_fn(C.gomcStatCallback), unsafe.Pointer(&handle)))
+	stats = collector.result()
+	return
+}
@@ -0,0 +1,24 @@
+package gomc
+
+import (
+	"testing"
+)
+
+func TestServerStatsParse(t *testing.T) {
+	collector := make(statsCollector)
+	collector.add("localhost:11211", "curr_items", "42")
+	collector.add("localhost:11211", "version", "1.6.21")
+	collector.add("localhost:11211", "1:chunk_size", "96")
+	collector.add("localhost:11212", "evictions", "7")
+
+	stats := collector.result()
+	if len(stats) != 2 {
+		t.Error("Error stats size:", len(stats), ", expect:", 2)
+	}
+	if s := stats["localhost:11211"]; s.CurrItems != 42 || s.Version != "1.6.21" || s.Raw["1:chunk_size"] != "96" {
+		t.Error("Error stats:", s)
+	}
+	if s := stats["localhost:11212"]; s.Evictions != 7 {
+		t.Error("Error evictions:", s.Evictions, ", expect:", 7)
+	}
+}
@@ -0,0 +1,125 @@
+package gomc
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	_TAG_VERSION_PREFIX = "__tag:"
+)
+
+// taggedEntry is what TaggedCache stores, gob encoded, in place of the value.
+type taggedEntry struct {
+	Value []byte
+	Flags uint32
+	Tags  map[string]uint64
+}
+
+// TaggedCache stores values along with the version of each of their tags.
+// InvalidateTag bumps the version of a tag, after which every entry stored
+// with the previous one reads as a miss. Tag versions are counters kept in
+// memcached, so an evicted tag invalidates its entries too.
+type TaggedCache struct {
+	client   Client
+	encoding EncodingType
+	now      func() time.Time
+}
+
+func NewTaggedCache(client Client, encoding EncodingType) *TaggedCache {
+	return &TaggedCache{
+		client:   client,
+		encoding: encoding,
+		now:      time.Now,
+	}
+}
+
+func tagKey(tag string) string {
+	return _TAG_VERSION_PREFIX + tag
+}
+
+// tagVersions fetches the versions of tags with a single GetMulti. Missing
+// tags are created when create is set and left out otherwise.
+func (self *TaggedCache) tagVersions(tags []string, create bool) (versions map[string]uint64, err error) {
+	versions = make(map[string]uint64, len(tags))
+	if len(tags) == 0 {
+		return
+	}
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = tagKey(tag)
+	}
+	res, err := self.client.GetMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, tag := range tags {
+		var version uint64
+		if res.Get(keys[i], &version) == nil {
+			versions[tag] = version
+		} else if create {
+			if version, err = fetchCounter(self.client, keys[i], uint64(self.now().UnixNano())); err != nil {
+				return nil, err
+			}
+			versions[tag] = version
+		}
+	}
+	return
+}
+
+func (self *TaggedCache) SetWithTags(key string, value interface{}, expiration time.Duration, tags ...string) (err error) {
+	entry := taggedEntry{}
+	if entry.Value, entry.Flags, err = encode(value, self.encoding); err != nil {
+		return
+	}
+	if entry.Tags, err = self.tagVersions(tags, true); err != nil {
+		return
+	}
+	buffer, err := encodeGob(entry)
+	if err != nil {
+		return
+	}
+	return self.client.Set(key, buffer, expiration)
+}
+
+// Get reads key like Client.Get, except that an entry with an invalidated tag
+// is reported as ErrNotFound.
+func (self *TaggedCache) Get(key string, value interface{}) (err error) {
+	var buffer []byte
+	if err = self.client.Get(key, &buffer); err != nil {
+		return
+	}
+	entry := taggedEntry{}
+	if err = decodeGob(buffer, &entry); err != nil {
+		return
+	}
+
+	tags := make([]string, 0, len(entry.Tags))
+	for tag := range entry.Tags {
+		tags = append(tags, tag)
+	}
+	versions, err := self.tagVersions(tags, false)
+	if err != nil {
+		return
+	}
+	for tag, version := range entry.Tags {
+		if current, ok := versions[tag]; !ok || current != version {
+			return &Error{Code: NOTFOUND, Message: "Tag `" + tag + "` of key `" + key + "` was invalidated"}
+		}
+	}
+	return decode(entry.Value, entry.Flags, value)
+}
+
+func (self *TaggedCache) Delete(key string) error {
+	return self.client.Delete(key, 0)
+}
+
+// InvalidateTag bumps the version of tag. A missing tag needs no bump, its
+// entries being invalid already.
+func (self *TaggedCache) InvalidateTag(tag string) error {
+	if _, err := self.client.Increment(tagKey(tag), 1); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package gomc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ianoshen/gomc/gomctest"
+)
+
+type product struct {
+	Name   string
+	Seller string
+}
+
+func TestTaggedCache(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	mc, err := newTestClient(testHosts[:1], ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+	cache := NewTaggedCache(mc, ENCODING_GOB)
+
+	if err = cache.SetWithTags("product:1", product{"foo", "x"}, 0, "seller:x", "category:a"); err != nil {
+		t.Error("Fail to set with tags:", err)
+	}
+	if err = cache.SetWithTags("product:2", product{"bar", "y"}, 0, "seller:y", "category:a"); err != nil {
+		t.Error("Fail to set with tags:", err)
+	}
+	if err = cache.SetWithTags("untagged", "baz", 0); err != nil {
+		t.Error("Fail to set without tags:", err)
+	}
+
+	var value product
+	if err = cache.Get("product:1", &value); err != nil || value.Name != "foo" {
+		t.Error("Error tagged value:", value, err)
+	}
+
+	if err = cache.InvalidateTag("seller:x"); err != nil {
+		t.Error("Fail to invalidate tag:", err)
+	}
+	if err = cache.Get("product:1", &value); !errors.Is(err, ErrNotFound) {
+		t.Error("Error invalidated value:", err)
+	}
+	if err = cache.Get("product:2", &value); err != nil || value.Name != "bar" {
+		t.Error("Error value with other tags:", value, err)
+	}
+
+	// An evicted tag invalidates its entries as well.
+	if err = mc.Delete(tagKey("category:a"), 0); err != nil {
+		t.Error("Fail to delete tag:", err)
+	}
+	if err = cache.Get("product:2", &value); !errors.Is(err, ErrNotFound) {
+		t.Error("Error value with evicted tag:", err)
+	}
+
+	var str string
+	if err = cache.Get("untagged", &str); err != nil || str != "baz" {
+		t.Error("Error untagged value:", str, err)
+	}
+	if err = cache.InvalidateTag("unknown"); err != nil {
+		t.Error("Fail to invalidate unknown tag:", err)
+	}
+}
@@ -0,0 +1,107 @@
+package gomc
+
+import (
+	"errors"
+	"time"
+)
+
+// Codec converts values of type T to the bytes and flags stored in memcached.
+type Codec[T any] interface {
+	Encode(T) ([]byte, uint32, error)
+	Decode([]byte, uint32) (T, error)
+}
+
+type encodingCodec[T any] struct {
+	encoding EncodingType
+}
+
+// NewEncodingCodec returns the codec the clients use for encoding, so that
+// values are readable by both TypedCache and Client.Get.
+func NewEncodingCodec[T any](encoding EncodingType) Codec[T] {
+	return encodingCodec[T]{encoding: encoding}
+}
+
+func (self encodingCodec[T]) Encode(value T) ([]byte, uint32, error) {
+	return encode(value, self.encoding)
+}
+
+func (self encodingCodec[T]) Decode(buffer []byte, flags uint32) (value T, err error) {
+	err = decode(buffer, flags, &value)
+	return
+}
+
+// TypedCache wraps a Client for values of a single type T, encoded with a
+// Codec instead of decoded into a pointer.
+type TypedCache[T any] struct {
+	client Client
+	codec  Codec[T]
+}
+
+func NewTypedCache[T any](client Client, codec Codec[T]) *TypedCache[T] {
+	return &TypedCache[T]{client: client, codec: codec}
+}
+
+// Get reports a miss with ok set to false rather than an error.
+func (self *TypedCache[T]) Get(key string) (value T, ok bool, err error) {
+	item := new(Item)
+	if err = self.client.Get(key, item); errors.Is(err, ErrNotFound) {
+		return value, false, nil
+	} else if err != nil {
+		return
+	}
+	if value, err = self.codec.Decode(item.Value, item.Flags); err != nil {
+		return
+	}
+	return value, true, nil
+}
+
+func (self *TypedCache[T]) item(value T) (item *Item, err error) {
+	item = new(Item)
+	item.Value, item.Flags, err = self.codec.Encode(value)
+	return
+}
+
+func (self *TypedCache[T]) Set(key string, value T, expiration time.Duration) error {
+	item, err := self.item(value)
+	if err != nil {
+		return err
+	}
+	return self.client.Set(key, item, expiration)
+}
+
+func (self *TypedCache[T]) Add(key string, value T, expiration time.Duration) error {
+	item, err := self.item(value)
+	if err != nil {
+		return err
+	}
+	return self.client.Add(key, item, expiration)
+}
+
+func (self *TypedCache[T]) Delete(key string) error {
+	return self.client.Delete(key, 0)
+}
+
+// GetMulti leaves missing keys out of values. The values that fail to decode
+// are left out too and reported in a MultiError.
+func (self *TypedCache[T]) GetMulti(keys []string) (values map[string]T, err error) {
+	res, err := self.client.GetMulti(keys)
+	if res == nil {
+		return
+	}
+
+	values = make(map[string]T, res.Len())
+	errs := make(MultiError)
+	for _, key := range res.Keys() {
+		item, _ := res.Item(key)
+		value, e := self.codec.Decode(item.Value, item.Flags)
+		if e != nil {
+			errs[key] = e
+			continue
+		}
+		values[key] = value
+	}
+	if err == nil {
+		err = errs.err()
+	}
+	return
+}
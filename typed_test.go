@@ -0,0 +1,71 @@
+package gomc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ianoshen/gomc/gomctest"
+)
+
+type typedValue struct {
+	Name  string
+	Count int
+}
+
+func TestEncodingCodec(t *testing.T) {
+	codec := NewEncodingCodec[typedValue](ENCODING_JSON)
+	buffer, flags, err := codec.Encode(typedValue{"foo", 1})
+	if err != nil || flags != encodingFlag(ENCODING_JSON) {
+		t.Error("Fail to encode:", flags, err)
+	}
+	if value, err := codec.Decode(buffer, flags); err != nil || value != (typedValue{"foo", 1}) {
+		t.Error("Error decoded value:", value, err)
+	}
+
+	// Values are readable through the untyped API too.
+	var value typedValue
+	if err = decode(buffer, flags, &value); err != nil || value.Name != "foo" {
+		t.Error("Error untyped value:", value, err)
+	}
+
+	item := new(Item)
+	if err = decode(buffer, flags, item); err != nil || string(item.Value) != string(buffer) || item.Flags != flags {
+		t.Error("Error raw item:", item, err)
+	}
+	if raw, rawFlags, err := encode(item, ENCODING_GOB); err != nil || string(raw) != string(buffer) || rawFlags != flags {
+		t.Error("Error raw encoding:", rawFlags, err)
+	}
+}
+
+func TestTypedCache(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	mc, err := newTestClient(testHosts, ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+	cache := NewTypedCache(mc, NewEncodingCodec[typedValue](ENCODING_GOB))
+
+	if err = cache.Set("foo", typedValue{"foo", 1}, 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	if value, ok, err := cache.Get("foo"); err != nil || !ok || value.Count != 1 {
+		t.Error("Error value:", value, ok, err)
+	}
+	if _, ok, err := cache.Get("missing"); err != nil || ok {
+		t.Error("Error missing value:", ok, err)
+	}
+	if err = cache.Add("foo", typedValue{"bar", 2}, 0); !errors.Is(err, ErrNotStored) {
+		t.Error("Error add:", err)
+	}
+
+	mc.Set("bad", "not gob", 0)
+	values, err := cache.GetMulti([]string{"foo", "missing", "bad"})
+	var errs MultiError
+	if !errors.As(err, &errs) || len(errs) != 1 || errs["bad"] == nil {
+		t.Error("Error decoding errors:", err)
+	}
+	if len(values) != 1 || values["foo"].Name != "foo" {
+		t.Error("Error values:", values)
+	}
+}
@@ -0,0 +1,143 @@
+package gomc
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	_NAMESPACE_COUNTER_PREFIX = "__ns:"
+	_NAMESPACE_SEPARATOR      = ":"
+)
+
+type namespaceVersion struct {
+	version uint64
+	expires time.Time
+}
+
+// VersionedNamespaces qualifies keys with the current version of their
+// namespace, so that bumping the version with InvalidateNamespace makes every
+// key of the namespace unreachable at once. The stale entries are left to
+// expire or be evicted by memcached.
+//
+// Versions are cached locally for ttl, which bounds how long other processes
+// may keep reading a namespace that has just been invalidated.
+type VersionedNamespaces struct {
+	client   Client
+	ttl      time.Duration
+	now      func() time.Time
+	lock     sync.Mutex
+	versions map[string]namespaceVersion
+}
+
+func NewVersionedNamespaces(client Client, ttl time.Duration) *VersionedNamespaces {
+	return &VersionedNamespaces{
+		client:   client,
+		ttl:      ttl,
+		now:      time.Now,
+		versions: make(map[string]namespaceVersion),
+	}
+}
+
+func counterKey(name string) string {
+	return _NAMESPACE_COUNTER_PREFIX + name
+}
+
+func (self *VersionedNamespaces) cached(name string) (version uint64, ok bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	cached, ok := self.versions[name]
+	if !ok || !self.now().Before(cached.expires) {
+		return 0, false
+	}
+	return cached.version, true
+}
+
+func (self *VersionedNamespaces) cache(name string, version uint64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.versions[name] = namespaceVersion{version: version, expires: self.now().Add(self.ttl)}
+}
+
+// fetchCounter reads a counter with a zero increment. A missing counter, never
+// set or evicted, is created with initial, which callers derive from the clock
+// so that it cannot go back to a version whose keys may still be stored.
+func fetchCounter(client Client, key string, initial uint64) (value uint64, err error) {
+	value, err = client.Increment(key, 0)
+	if !errors.Is(err, ErrNotFound) {
+		return
+	}
+	if err = client.Add(key, initial, 0); err == nil {
+		return initial, nil
+	}
+	if errors.Is(err, ErrNotStored) {
+		return client.Increment(key, 0)
+	}
+	return
+}
+
+func (self *VersionedNamespaces) Version(name string) (version uint64, err error) {
+	if version, ok := self.cached(name); ok {
+		return version, nil
+	}
+	if version, err = fetchCounter(self.client, counterKey(name), uint64(self.now().UnixNano())); err != nil {
+		return
+	}
+	self.cache(name, version)
+	return
+}
+
+// Qualify returns the key actually stored in memcached for key in namespace
+// name.
+func (self *VersionedNamespaces) Qualify(name, key string) (string, error) {
+	version, err := self.Version(name)
+	if err != nil {
+		return "", err
+	}
+	return name + _NAMESPACE_SEPARATOR + strconv.FormatUint(version, _NUMERIC_BASE) + _NAMESPACE_SEPARATOR + key, nil
+}
+
+func (self *VersionedNamespaces) Get(name, key string, value interface{}) (err error) {
+	qualified, err := self.Qualify(name, key)
+	if err != nil {
+		return
+	}
+	return self.client.Get(qualified, value)
+}
+
+func (self *VersionedNamespaces) Set(name, key string, value interface{}, expiration time.Duration) (err error) {
+	qualified, err := self.Qualify(name, key)
+	if err != nil {
+		return
+	}
+	return self.client.Set(qualified, value, expiration)
+}
+
+func (self *VersionedNamespaces) Delete(name, key string) (err error) {
+	qualified, err := self.Qualify(name, key)
+	if err != nil {
+		return
+	}
+	return self.client.Delete(qualified, 0)
+}
+
+// InvalidateNamespace bumps the version of namespace name. A missing counter
+// needs no bump, the next read creating a fresh one.
+func (self *VersionedNamespaces) InvalidateNamespace(name string) (err error) {
+	version, err := self.client.Increment(counterKey(name), 1)
+	if errors.Is(err, ErrNotFound) {
+		self.lock.Lock()
+		delete(self.versions, name)
+		self.lock.Unlock()
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	self.cache(name, version)
+	return
+}
@@ -0,0 +1,54 @@
+package gomc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ianoshen/gomc/gomctest"
+)
+
+func TestVersionedNamespaces(t *testing.T) {
+	gomctest.StartAt(t, testHosts...)
+
+	mc, err := newTestClient(testHosts[:1], ENCODING_DEFAULT)
+	if err != nil {
+		t.Error("Fail to new client:", err)
+	}
+	now := time.Now()
+	namespaces := NewVersionedNamespaces(mc, time.Minute)
+	namespaces.now = func() time.Time { return now }
+	other := NewVersionedNamespaces(mc, time.Minute)
+
+	if err = namespaces.Set("seller", "foo", "bar", 0); err != nil {
+		t.Error("Fail to set:", err)
+	}
+	var value string
+	if err = other.Get("seller", "foo", &value); err != nil || value != "bar" {
+		t.Error("Error value from other client:", value, err)
+	}
+
+	before, _ := namespaces.Qualify("seller", "foo")
+	if err = other.InvalidateNamespace("seller"); err != nil {
+		t.Error("Fail to invalidate namespace:", err)
+	}
+	if err = other.Get("seller", "foo", &value); !errors.Is(err, ErrNotFound) {
+		t.Error("Error invalidated key:", err)
+	}
+
+	// The local version is kept until its TTL expires.
+	if qualified, _ := namespaces.Qualify("seller", "foo"); qualified != before {
+		t.Error("Error cached qualified key:", qualified)
+	}
+	now = now.Add(time.Minute)
+	if qualified, _ := namespaces.Qualify("seller", "foo"); qualified == before {
+		t.Error("Error expired qualified key:", qualified)
+	}
+	if err = namespaces.Get("seller", "foo", &value); !errors.Is(err, ErrNotFound) {
+		t.Error("Error invalidated key after TTL:", err)
+	}
+
+	if err = namespaces.InvalidateNamespace("unknown"); err != nil {
+		t.Error("Fail to invalidate unknown namespace:", err)
+	}
+}